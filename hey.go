@@ -16,10 +16,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"net/http"
 	gourl "net/url"
 	"os"
@@ -49,7 +51,30 @@ var (
 	hostHeader  = flag.String("host", "", "")
 	userAgent   = flag.String("U", "", "")
 
-	output = flag.String("o", "", "")
+	output        = flag.String("o", "", "")
+	outputFile    = flag.String("out-file", "", "")
+	appendOutput  = flag.Bool("append", false, "")
+	streamSummary = flag.Bool("stream-summary", false, "")
+	progress      = flag.Int("progress", 0, "")
+	progressWin   = flag.Int("progress-window", 0, "")
+	summary       = flag.Bool("summary-line", false, "")
+	structured    = flag.String("structured-summary", "", "")
+	reportURL     = flag.String("report-url", "", "")
+
+	syslogTag      = flag.String("syslog", "", "")
+	syslogFacility = flag.String("syslog-facility", "", "")
+
+	influxMeasurement = flag.String("influx-measurement", "", "")
+	influxTags        = flag.String("influx-tags", "", "")
+
+	maxErrorRate = flag.Float64("max-error-rate", 0, "")
+	slo          = flag.String("slo", "", "")
+	quiet        = flag.Bool("quiet", false, "")
+	maxSamples   = flag.Int("max-samples", 0, "")
+	reservoir    = flag.Bool("reservoir", false, "")
+	streamPctls  = flag.Bool("stream-percentiles", false, "")
+	seed         = flag.Int64("seed", 0, "")
+	bootstrap    = flag.Int("bootstrap", 0, "")
 
 	c = flag.Int("c", 50, "")
 	n = flag.Int("n", 200, "")
@@ -57,6 +82,14 @@ var (
 	t = flag.Int("t", 20, "")
 	z = flag.Duration("z", 0, "")
 
+	warmup = flag.Duration("warmup", 0, "")
+	apdexT = flag.Duration("apdex", 0, "")
+
+	buckets      = flag.Int("histogram-buckets", 10, "")
+	logHistogram = flag.Bool("histogram-log", false, "")
+	bounds       = flag.String("histogram-bounds", "", "")
+	unit         = flag.String("unit", "s", "")
+
 	h2   = flag.Bool("h2", false, "")
 	cpus = flag.Int("cpus", runtime.GOMAXPROCS(-1), "")
 
@@ -66,6 +99,12 @@ var (
 	proxyAddr          = flag.String("x", "", "")
 )
 
+var latencyUnits = map[string]requester.LatencyUnit{
+	"s":  requester.Seconds,
+	"ms": requester.Milliseconds,
+	"us": requester.Microseconds,
+}
+
 var usage = `Usage: hey [options...] <url>
 
 Options:
@@ -77,8 +116,18 @@ Options:
       application stops and exits. If duration is specified, n is ignored.
       Examples: -z 10s -z 3m.
   -o  Output type. If none provided, a summary is printed.
-      "csv" is the only supported alternative. Dumps the response
-      metrics in comma-separated values format.
+      "compact" prints a summary that fits narrow terminals.
+      "markdown" prints the summary as Markdown tables.
+      "csv" streams the response metrics of each request in
+      comma-separated values format.
+      "json" dumps the full report as a JSON object.
+      "yaml" dumps the full report as YAML.
+      "ndjson" streams a JSON object per request, one per line.
+      "prometheus" dumps the metrics in Prometheus text format.
+      "influx" dumps the metrics as a line of InfluxDB line protocol.
+      "html" dumps the report as an HTML page with charts.
+      "sketch" dumps a binary latency sketch, which can be merged
+      with the sketches of other runs.
 
   -m  HTTP method, one of GET, POST, PUT, DELETE, HEAD, OPTIONS.
   -H  Custom HTTP header. You can specify as many as needed by repeating the flag.
@@ -99,6 +148,61 @@ Options:
   -disable-keepalive    Disable keep-alive, prevents re-use of TCP
                         connections between different HTTP requests.
   -disable-redirects    Disable following of HTTP redirects
+  -histogram-buckets    Number of buckets of the response time histogram.
+                        Default is 10.
+  -histogram-log        Space the histogram buckets logarithmically.
+  -histogram-bounds     Fixed marks of the histogram buckets, so that the
+                        histograms of several runs are comparable.
+                        Examples: -histogram-bounds 1ms,10ms,100ms,1s.
+  -unit                 Unit of the latencies in the summary, one of s, ms
+                        and us. Default is s.
+  -stream-summary       Write the full report as the last line of the
+                        "ndjson" output.
+  -out-file             Write the output to the given file instead of stdout.
+                        The file is compressed with gzip if its name ends in .gz.
+  -append               Append to the -out-file instead of replacing it. The
+                        "json" report is written on a single line, so that
+                        the file holds one report per run.
+  -summary-line         End the summary with a single line summary of the run.
+  -structured-summary   Also write the key metrics of the run to stderr as a
+                        single line, in the given format: logfmt or json.
+  -report-url           Post the report as JSON to the given URL once the run
+                        is done, e.g. to a collector service.
+  -syslog               Also write the summary line and the errors of the run to
+                        syslog, with the given tag.
+  -syslog-facility      Facility of the syslog messages, e.g. daemon or local0.
+                        Default is user.
+  -influx-measurement   Measurement of the "influx" output. Default is hey.
+  -influx-tags          Tags of the "influx" output, e.g. host=web-1,region=eu.
+  -progress             Print the progress to stderr every given number
+                        of requests. Default is no progress.
+  -progress-window      Also print the p99 latency of the given number of the
+                        latest requests with the progress.
+  -max-error-rate       Exit with a non-zero status if the fraction of failed
+                        requests exceeds the given value, e.g. 0.01 for 1%%.
+  -slo                  Exit with a non-zero status if any of the given rules
+                        fails. Rules are on p<percentile>, average, fastest,
+                        slowest, rps or errorRate, e.g. -slo "p99<200ms,rps>=100".
+  -quiet                Print the report only if the run fails -max-error-rate
+                        or -slo.
+  -warmup               Duration at the start of the run whose requests are
+                        discarded from the report. Examples: -warmup 5s.
+  -apdex                Threshold of the Apdex score of the responses.
+                        Examples: -apdex 100ms. Default is no score.
+  -max-samples          Maximum number of responses whose response times are
+                        recorded for the report. Default is a million.
+  -reservoir            Once the maximum number of responses is recorded, keep
+                        a uniform random sample of the responses rather than
+                        the first ones.
+  -stream-percentiles   Estimate the percentiles as the responses arrive instead
+                        of recording the response times, so that memory stays
+                        constant. The percentiles are approximate and the
+                        histogram is left out.
+  -bootstrap            Estimate a 95%% confidence band of the p95 latency from
+                        the given number of resamples of the response times,
+                        e.g. 1000. Default is no band.
+  -seed                 Seed of the random sampling, to make it reproducible.
+                        Default is a seed based on the current time.
   -cpus                 Number of used cpu cores.
                         (default for current machine is %d cores)
 `
@@ -137,6 +241,65 @@ func main() {
 		}
 	}
 
+	if *maxSamples < 0 {
+		usageAndExit("-max-samples cannot be negative.")
+	}
+
+	switch *structured {
+	case "", "logfmt", "json":
+	default:
+		usageAndExit("-structured-summary must be one of logfmt and json.")
+	}
+
+	if *appendOutput && *outputFile == "" {
+		usageAndExit("-append requires -out-file.")
+	}
+
+	if *bootstrap < 0 {
+		usageAndExit("-bootstrap cannot be negative.")
+	}
+
+	if *progressWin < 0 {
+		usageAndExit("-progress-window cannot be negative.")
+	}
+
+	if *buckets < 1 {
+		usageAndExit("-histogram-buckets cannot be smaller than 1.")
+	}
+
+	var histogramBounds []time.Duration
+	if *bounds != "" {
+		for _, s := range strings.Split(*bounds, ",") {
+			bound, err := time.ParseDuration(strings.TrimSpace(s))
+			if err != nil {
+				usageAndExit("-histogram-bounds must be a comma-separated list of durations.")
+			}
+			histogramBounds = append(histogramBounds, bound)
+		}
+	}
+
+	latencyUnit, ok := latencyUnits[*unit]
+	if !ok {
+		usageAndExit("-unit must be one of s, ms and us.")
+	}
+
+	rules, err := requester.ParseSLO(*slo)
+	if err != nil {
+		usageAndExit(err.Error())
+	}
+
+	var tags map[string]string
+	if *influxTags != "" {
+		tags = make(map[string]string)
+		for _, s := range strings.Split(*influxTags, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(s), "=")
+			if !ok || k == "" || v == "" {
+				usageAndExit("-influx-tags must be a comma-separated list of key=value pairs.")
+			}
+			tags[k] = v
+		}
+	}
+
 	url := flag.Args()[0]
 	method := strings.ToUpper(*m)
 
@@ -222,26 +385,59 @@ func main() {
 	req.Header = header
 
 	w := &requester.Work{
-		Request:            req,
-		RequestBody:        bodyAll,
-		N:                  num,
-		C:                  conc,
-		QPS:                q,
-		Timeout:            *t,
-		DisableCompression: *disableCompression,
-		DisableKeepAlives:  *disableKeepAlives,
-		DisableRedirects:   *disableRedirects,
-		H2:                 *h2,
-		ProxyAddr:          proxyURL,
-		Output:             *output,
+		Request:              req,
+		RequestBody:          bodyAll,
+		N:                    num,
+		C:                    conc,
+		QPS:                  q,
+		Timeout:              *t,
+		DisableCompression:   *disableCompression,
+		DisableKeepAlives:    *disableKeepAlives,
+		DisableRedirects:     *disableRedirects,
+		H2:                   *h2,
+		ProxyAddr:            proxyURL,
+		HistogramBuckets:     *buckets,
+		LogHistogram:         *logHistogram,
+		HistogramBounds:      histogramBounds,
+		LatencyUnit:          latencyUnit,
+		Output:               *output,
+		OutputFile:           *outputFile,
+		StreamSummary:        *streamSummary,
+		SummaryLine:          *summary,
+		ReportURL:            *reportURL,
+		ProgressInterval:     *progress,
+		MaxErrorRate:         *maxErrorRate,
+		SLO:                  rules,
+		MaxSamples:           *maxSamples,
+		ReservoirSampling:    *reservoir,
+		Warmup:               *warmup,
+		ApdexT:               *apdexT,
+		StreamingPercentiles: *streamPctls,
+		Quiet:                *quiet,
+		ProgressWindow:       *progressWin,
+		StructuredSummary:    *structured,
+		AppendOutput:         *appendOutput,
+		Bootstrap:            *bootstrap > 0,
+		BootstrapResamples:   *bootstrap,
+		SyslogTag:            *syslogTag,
+		SyslogFacility:       *syslogFacility,
+		InfluxMeasurement:    *influxMeasurement,
+		InfluxTags:           tags,
 	}
+	// Any seed can be chosen, 0 included, so the flag is looked up.
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			w.RandSource = rand.NewSource(*seed)
+		}
+	})
 	w.Init()
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	// On interrupt, print the report of the requests made so far. A second
+	// interrupt exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	go func() {
-		<-c
-		w.Stop()
+		<-ctx.Done()
+		stop()
 	}()
 	if dur > 0 {
 		go func() {
@@ -249,18 +445,20 @@ func main() {
 			w.Stop()
 		}()
 	}
-	w.Run()
+	if err := w.RunContext(ctx); err != nil {
+		errAndExit(err.Error())
+	}
 }
 
 func errAndExit(msg string) {
-	fmt.Fprintf(os.Stderr, msg)
+	fmt.Fprint(os.Stderr, msg)
 	fmt.Fprintf(os.Stderr, "\n")
 	os.Exit(1)
 }
 
 func usageAndExit(msg string) {
 	if msg != "" {
-		fmt.Fprintf(os.Stderr, msg)
+		fmt.Fprint(os.Stderr, msg)
 		fmt.Fprintf(os.Stderr, "\n\n")
 	}
 	flag.Usage()
@@ -0,0 +1,145 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"text/tabwriter"
+)
+
+// DefaultCompareTolerance is the relative change of a metric beyond
+// which CompareReports flags a regression, e.g. 0.05 for 5%.
+const DefaultCompareTolerance = 0.05
+
+// MetricDelta is the change of a metric between two reports.
+type MetricDelta struct {
+	Metric   string
+	Baseline float64
+	Current  float64
+	// Delta is Current - Baseline, and Change the delta relative to
+	// Baseline, zero if Baseline is.
+	Delta  float64
+	Change float64
+	// Regressed is set if the metric got worse by more than the
+	// tolerance of the comparison.
+	Regressed bool
+
+	// higherIsBetter is set for throughputs, unset for latencies and
+	// error rates.
+	higherIsBetter bool
+}
+
+// Comparison is the comparison of a report with a baseline, see
+// CompareReports.
+type Comparison struct {
+	Tolerance float64
+	Metrics   []MetricDelta
+}
+
+// CompareReports compares the rps, the average, p50, p95 and p99
+// latencies and the error rate of the current report with the ones of
+// the baseline, e.g. a report saved as JSON before a change. Any metric
+// worse by more than DefaultCompareTolerance is flagged as a regression.
+func CompareReports(baseline, current Report) Comparison {
+	metric := func(name string, higherIsBetter bool, get func(Report) float64) MetricDelta {
+		return MetricDelta{
+			Metric:         name,
+			Baseline:       get(baseline),
+			Current:        get(current),
+			higherIsBetter: higherIsBetter,
+		}
+	}
+	pctl := func(p float64) func(Report) float64 {
+		return func(rep Report) float64 { return reportPercentile(rep, p) }
+	}
+	c := Comparison{Metrics: []MetricDelta{
+		metric("rps", true, func(rep Report) float64 { return rep.Rps }),
+		metric("average", false, func(rep Report) float64 { return rep.Average }),
+		metric("p50", false, pctl(50)),
+		metric("p95", false, pctl(95)),
+		metric("p99", false, pctl(99)),
+		metric("errorRate", false, func(rep Report) float64 { return rep.ErrorRate }),
+	}}
+	return c.WithTolerance(DefaultCompareTolerance)
+}
+
+// WithTolerance returns the comparison with regressions flagged beyond
+// the given relative change instead.
+func (c Comparison) WithTolerance(tolerance float64) Comparison {
+	res := Comparison{Tolerance: tolerance, Metrics: make([]MetricDelta, len(c.Metrics))}
+	for i, m := range c.Metrics {
+		m.Delta = m.Current - m.Baseline
+		m.Change = 0
+		if m.Baseline != 0 {
+			m.Change = m.Delta / m.Baseline
+		}
+		worse := m.Delta > 0
+		if m.higherIsBetter {
+			worse = m.Delta < 0
+		}
+		// Without a baseline to be relative to, e.g. no errors, any
+		// worsening is a regression.
+		m.Regressed = worse && (m.Baseline == 0 || math.Abs(m.Change) > tolerance)
+		res.Metrics[i] = m
+	}
+	return res
+}
+
+// Regressed reports whether any metric regressed.
+func (c Comparison) Regressed() bool {
+	for _, m := range c.Metrics {
+		if m.Regressed {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders the comparison as a table with a row per metric.
+func (c Comparison) String() string {
+	buf := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Metric\tBaseline\tCurrent\tDelta\tChange\tRegressed")
+	for _, m := range c.Metrics {
+		regressed := "no"
+		if m.Regressed {
+			regressed = "YES"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", m.Metric, formatNumber(m.Baseline),
+			formatNumber(m.Current), formatNumber(m.Delta), formatPercent(m.Change), regressed)
+	}
+	tw.Flush()
+	return buf.String()
+}
+
+// reportPercentile returns the p-th percentile of the latencies of rep,
+// from its latency distribution if it has it, or else from its samples.
+func reportPercentile(rep Report, p float64) float64 {
+	for _, d := range rep.LatencyDistribution {
+		if d.Percentage == p {
+			return d.Latency
+		}
+	}
+	lats := rep.SortedLats
+	if len(lats) == 0 {
+		lats = make([]float64, len(rep.Lats))
+		copy(lats, rep.Lats)
+		sort.Float64s(lats)
+	}
+	return percentile(lats, p, NearestRank)
+}
@@ -0,0 +1,92 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import "testing"
+
+func TestCompareReports(t *testing.T) {
+	baseline := Report{
+		Rps:       1000,
+		Average:   0.020,
+		ErrorRate: 0,
+		LatencyDistribution: []LatencyDistribution{
+			{Percentage: 50, Latency: 0.015},
+			{Percentage: 95, Latency: 0.040},
+			{Percentage: 99, Latency: 0.100},
+		},
+	}
+	// p99 comes from the samples of a report without that percentile.
+	current := Report{
+		Rps:       900,
+		Average:   0.0205,
+		ErrorRate: 0.01,
+		LatencyDistribution: []LatencyDistribution{
+			{Percentage: 50, Latency: 0.012},
+			{Percentage: 95, Latency: 0.041},
+		},
+	}
+	for i := 1; i <= 100; i++ {
+		current.Lats = append(current.Lats, float64(i)*0.0015)
+	}
+
+	c := CompareReports(baseline, current)
+	want := []struct {
+		metric            string
+		baseline, current float64
+		change            float64
+		regressed         bool
+	}{
+		{"rps", 1000, 900, -0.1, true},
+		{"average", 0.020, 0.0205, 0.025, false},
+		{"p50", 0.015, 0.012, -0.2, false},
+		{"p95", 0.040, 0.041, 0.025, false},
+		{"p99", 0.100, 0.1485, 0.485, true},
+		{"errorRate", 0, 0.01, 0, true},
+	}
+	if len(c.Metrics) != len(want) {
+		t.Fatalf("got %d metrics; want %d", len(c.Metrics), len(want))
+	}
+	for i, w := range want {
+		m := c.Metrics[i]
+		if m.Metric != w.metric || m.Baseline != w.baseline || !approx(m.Current, w.current) ||
+			!approx(m.Delta, w.current-w.baseline) || !approx(m.Change, w.change) || m.Regressed != w.regressed {
+			t.Errorf("metric %d = %+v; want %s %v -> %v, change %v, regressed %v",
+				i, m, w.metric, w.baseline, w.current, w.change, w.regressed)
+		}
+	}
+	if !c.Regressed() {
+		t.Error("Regressed() = false; want true")
+	}
+
+	// A looser tolerance lets the rps drop of 10% pass.
+	if m := c.WithTolerance(0.15).Metrics[0]; m.Regressed {
+		t.Errorf("rps with a tolerance of 15%% = %+v; want no regression", m)
+	}
+	if c := CompareReports(baseline, baseline); c.Regressed() {
+		t.Errorf("a report compared with itself regressed:\n%v", c)
+	}
+
+	wantTable := `Metric     Baseline   Current   Delta      Change   Regressed
+rps        1000.0000  900.0000  -100.0000  -10.00%  YES
+average    0.0200     0.0205    0.0005     2.50%    no
+p50        0.0150     0.0120    -0.0030    -20.00%  no
+p95        0.0400     0.0410    0.0010     2.50%    no
+p99        0.1000     0.1485    0.0485     48.50%   YES
+errorRate  0.0000     0.0100    0.0100     0.00%    YES
+`
+	if got := CompareReports(baseline, current).String(); got != wantTable {
+		t.Errorf("table:\n%s\nwant:\n%s", got, wantTable)
+	}
+}
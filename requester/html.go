@@ -0,0 +1,133 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"html/template"
+	"io"
+)
+
+// htmlChart is the data of the charts of the HTML report.
+type htmlChart struct {
+	Histogram  []Bucket
+	Throughput []ThroughputPoint
+}
+
+// writeHTML writes the report to w as a self-contained HTML page.
+func writeHTML(w io.Writer, rep Report) error {
+	return htmlTmpl.Execute(w, struct {
+		Report
+		Chart htmlChart
+	}{rep, htmlChart{rep.Histogram, rep.Throughput}})
+}
+
+var htmlTmpl = template.Must(template.New("html").Funcs(template.FuncMap{
+	"formatNumber": formatNumber,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>hey report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.2em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+canvas { border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>hey report{{ if .Partial }} (partial, the run was interrupted){{ end }}</h1>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Requests</th><td>{{ .NumRes }}</td></tr>
+<tr><th>Errors</th><td>{{ .NumErrs }}</td></tr>
+<tr><th>Total</th><td>{{ formatNumber .Total.Seconds }} secs</td></tr>
+<tr><th>Slowest</th><td>{{ formatNumber .Slowest }} secs</td></tr>
+<tr><th>Fastest</th><td>{{ formatNumber .Fastest }} secs</td></tr>
+<tr><th>Average</th><td>{{ formatNumber .Average }} secs</td></tr>
+<tr><th>Median</th><td>{{ formatNumber .Median }} secs</td></tr>
+<tr><th>Requests/sec</th><td>{{ formatNumber .Rps }}</td></tr>
+</table>
+
+<h2>Response time histogram</h2>
+<canvas id="histogram" width="800" height="300"></canvas>
+
+<h2>Latency over time</h2>
+<canvas id="timeline" width="800" height="300"></canvas>
+
+<h2>Latency distribution</h2>
+<table>
+{{ range .LatencyDistribution }}<tr><th>{{ .Percentage }}%</th><td>{{ formatNumber .Latency }} secs</td></tr>
+{{ end }}</table>
+
+<h2>Status code distribution</h2>
+<table>
+{{ range $code, $num := .StatusCodeDist }}<tr><th>{{ $code }}</th><td>{{ $num }} responses</td></tr>
+{{ end }}</table>
+{{ if .ErrorDist }}
+<h2>Errors</h2>
+<table>
+{{ range $err, $num := .ErrorDist }}<tr><th>{{ $num }}</th><td>{{ $err }}</td></tr>
+{{ end }}</table>
+{{ end }}
+<script id="data" type="application/json">{{ .Chart }}</script>
+<script>
+(function () {
+  var data = JSON.parse(document.getElementById("data").textContent);
+
+  // chart draws ys as bars or as a line, labelled with the first and
+  // the last of xs and the largest of ys.
+  function chart(id, xs, ys, bars) {
+    var canvas = document.getElementById(id);
+    var ctx = canvas.getContext("2d");
+    var pad = 50, w = canvas.width - 2 * pad, h = canvas.height - 2 * pad;
+    var max = Math.max.apply(null, ys.concat([0])) || 1;
+    var step = w / Math.max(bars ? ys.length : ys.length - 1, 1);
+    ctx.fillStyle = ctx.strokeStyle = "#36c";
+    ctx.beginPath();
+    ys.forEach(function (y, i) {
+      var x = pad + i * step, top = pad + h - y / max * h;
+      if (bars) {
+        ctx.fillRect(x + 1, top, step - 2, pad + h - top);
+      } else if (i === 0) {
+        ctx.moveTo(x, top);
+      } else {
+        ctx.lineTo(x, top);
+      }
+    });
+    if (!bars) {
+      ctx.stroke();
+    }
+    ctx.fillStyle = "#000";
+    ctx.fillText(String(max), 5, pad);
+    if (xs.length) {
+      ctx.fillText(String(xs[0]), pad, pad + h + 15);
+      ctx.fillText(String(xs[xs.length - 1]), pad + w - 40, pad + h + 15);
+    }
+  }
+
+  var histogram = data.Histogram || [], throughput = data.Throughput || [];
+  chart("histogram",
+    histogram.map(function (b) { return b.Mark.toFixed(4) + " secs"; }),
+    histogram.map(function (b) { return b.Count; }), true);
+  chart("timeline",
+    throughput.map(function (p) { return p.Second + " secs"; }),
+    throughput.map(function (p) { return p.AvgLatency; }), false);
+})();
+</script>
+</body>
+</html>
+`))
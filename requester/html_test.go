@@ -0,0 +1,82 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrintHTML(t *testing.T) {
+	r := newTestReport(4)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "html"
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), offset: 0},
+		&result{statusCode: 200, duration: ms(20), offset: time.Second},
+		&result{statusCode: 503, duration: ms(30), offset: time.Second},
+		&result{err: errors.New(`dial tcp: <refused> & "closed"`)},
+	)
+	r.finalize(2 * time.Second)
+	out := buf.String()
+
+	// The page is well-formed, in the HTML sense.
+	d := xml.NewDecoder(strings.NewReader(out))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid HTML: %v\n%s", err, out)
+		}
+	}
+
+	for _, want := range []string{
+		"<tr><th>Requests</th><td>4</td></tr>",
+		"<tr><th>Errors</th><td>1</td></tr>",
+		"<tr><th>503</th><td>1 responses</td></tr>",
+		"<td>dial tcp: &lt;refused&gt; &amp; &#34;closed&#34;</td>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+
+	// The charts are drawn from the embedded data.
+	m := regexp.MustCompile(`(?s)<script id="data" type="application/json">(.*?)</script>`).FindStringSubmatch(out)
+	if m == nil {
+		t.Fatalf("output does not embed the chart data")
+	}
+	var chart htmlChart
+	if err := json.Unmarshal([]byte(m[1]), &chart); err != nil {
+		t.Fatalf("invalid chart data %q: %v", m[1], err)
+	}
+	if len(chart.Histogram) != defaultHistogramBuckets+1 {
+		t.Errorf("got %d histogram buckets; want %d", len(chart.Histogram), defaultHistogramBuckets+1)
+	}
+	if len(chart.Throughput) != 2 || chart.Throughput[1].Count != 2 || !approx(chart.Throughput[1].AvgLatency, 0.025) {
+		t.Errorf("Throughput = %+v; want 1 request in the first second and 2 of 25ms on average in the next", chart.Throughput)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultInfluxMeasurement is the measurement of the InfluxDB output if
+// none is set.
+const defaultInfluxMeasurement = "hey"
+
+// writeInflux writes the report to w as a line of the InfluxDB line
+// protocol, of the given measurement and tags, timestamped with the end
+// of the run.
+func writeInflux(w io.Writer, measurement string, tags map[string]string, rep Report) error {
+	if measurement == "" {
+		measurement = defaultInfluxMeasurement
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString(influxMeasurementEscaper.Replace(measurement))
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if tags[k] == "" {
+			// Empty tag values are invalid.
+			continue
+		}
+		bw.WriteString("," + influxTagEscaper.Replace(k) + "=" + influxTagEscaper.Replace(tags[k]))
+	}
+
+	fields := []string{
+		"requests=" + strconv.FormatInt(rep.NumRes, 10) + "i",
+		"errors=" + strconv.FormatInt(rep.NumErrs, 10) + "i",
+	}
+	float := func(key string, v float64) {
+		// NaN and infinities are not valid field values.
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			fields = append(fields, influxTagEscaper.Replace(key)+"="+formatInfluxFloat(v))
+		}
+	}
+	float("rps", rep.Rps)
+	float("error_rate", rep.ErrorRate)
+	float("average", rep.Average)
+	float("fastest", rep.Fastest)
+	float("slowest", rep.Slowest)
+	for _, ld := range rep.LatencyDistribution {
+		float("p"+formatInfluxFloat(ld.Percentage), ld.Latency)
+	}
+	bw.WriteString(" " + strings.Join(fields, ","))
+	if !rep.EndTime.IsZero() {
+		bw.WriteString(" " + strconv.FormatInt(rep.EndTime.UnixNano(), 10))
+	}
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// formatInfluxFloat formats v in decimal notation, since the line
+// protocol does not accept exponents.
+func formatInfluxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+)
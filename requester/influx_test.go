@@ -0,0 +1,186 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// influxLine is a parsed line of the InfluxDB line protocol.
+type influxLine struct {
+	measurement string
+	tags        map[string]string
+	fields      map[string]string
+	timestamp   string
+}
+
+// splitInflux splits s at the unescaped occurrences of sep, and
+// unescapes the parts.
+func splitInflux(s string, sep byte) []string {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			part.WriteByte(s[i])
+		case s[i] == sep:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(s[i])
+		}
+	}
+	return append(parts, part.String())
+}
+
+// parseInflux tokenizes a line of the line protocol without string
+// fields, which the output does not have.
+func parseInflux(t *testing.T, line string) influxLine {
+	t.Helper()
+	// Split at the unescaped spaces, keeping the escapes for the parts.
+	var sections []string
+	start := 0
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+		} else if line[i] == ' ' {
+			sections = append(sections, line[start:i])
+			start = i + 1
+		}
+	}
+	sections = append(sections, line[start:])
+	if len(sections) < 2 || len(sections) > 3 {
+		t.Fatalf("line %q has %d sections; want 2 or 3", line, len(sections))
+	}
+	res := influxLine{tags: map[string]string{}, fields: map[string]string{}}
+	if len(sections) == 3 {
+		res.timestamp = sections[2]
+	}
+	series := splitInflux(sections[0], ',')
+	res.measurement = series[0]
+	// Tags and fields are unescaped after they are split at the commas
+	// and at the equal signs, so split the escaped sections first.
+	for _, tag := range splitEscaped(sections[0])[1:] {
+		kv := splitInflux(tag, '=')
+		if len(kv) != 2 || kv[1] == "" {
+			t.Fatalf("invalid tag %q in line %q", tag, line)
+		}
+		res.tags[kv[0]] = kv[1]
+	}
+	for _, field := range splitEscaped(sections[1]) {
+		kv := splitInflux(field, '=')
+		if len(kv) != 2 {
+			t.Fatalf("invalid field %q in line %q", field, line)
+		}
+		res.fields[kv[0]] = kv[1]
+	}
+	return res
+}
+
+// splitEscaped splits s at the unescaped commas, leaving the escapes.
+func splitEscaped(s string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == ',' {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func TestWriteInflux(t *testing.T) {
+	end := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	rep := Report{
+		NumRes:    10,
+		NumErrs:   1,
+		Rps:       123.5,
+		ErrorRate: 0.1,
+		Average:   0.0000001,
+		Fastest:   1e-9,
+		Slowest:   2.5e6,
+		LatencyDistribution: []LatencyDistribution{
+			{Percentage: 50, Latency: 0.01},
+			{Percentage: 99.9, Latency: 0.25},
+		},
+		EndTime: end,
+	}
+	var buf bytes.Buffer
+	if err := writeInflux(&buf, "load test", map[string]string{"host": "web 1", "env": "a=b,c", "empty": ""}, rep); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Count(out, "\n") != 1 || !strings.HasSuffix(out, "\n") {
+		t.Fatalf("output %q is not a single line", out)
+	}
+	line := parseInflux(t, strings.TrimSuffix(out, "\n"))
+	if line.measurement != "load test" {
+		t.Errorf("measurement = %q; want %q", line.measurement, "load test")
+	}
+	if want := map[string]string{"host": "web 1", "env": "a=b,c"}; len(line.tags) != len(want) || line.tags["host"] != want["host"] || line.tags["env"] != want["env"] {
+		t.Errorf("tags = %v; want %v", line.tags, want)
+	}
+	if line.timestamp != strconv.FormatInt(end.UnixNano(), 10) {
+		t.Errorf("timestamp = %q; want %d", line.timestamp, end.UnixNano())
+	}
+	want := map[string]float64{
+		"rps":        123.5,
+		"error_rate": 0.1,
+		"average":    0.0000001,
+		"fastest":    1e-9,
+		"slowest":    2.5e6,
+		"p50":        0.01,
+		"p99.9":      0.25,
+	}
+	for key, v := range want {
+		got, ok := line.fields[key]
+		if !ok {
+			t.Errorf("field %s is missing in %q", key, out)
+			continue
+		}
+		if strings.ContainsAny(got, "eE") {
+			t.Errorf("field %s = %s; want no exponent", key, got)
+		}
+		if f, err := strconv.ParseFloat(got, 64); err != nil || f != v {
+			t.Errorf("field %s = %s; want %v", key, got, v)
+		}
+	}
+	if line.fields["requests"] != "10i" || line.fields["errors"] != "1i" {
+		t.Errorf("requests, errors = %s, %s; want 10i, 1i", line.fields["requests"], line.fields["errors"])
+	}
+}
+
+func TestWriteInfluxDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeInflux(&buf, "", nil, Report{Rps: math.NaN()}); err != nil {
+		t.Fatal(err)
+	}
+	line := parseInflux(t, strings.TrimSuffix(buf.String(), "\n"))
+	if line.measurement != defaultInfluxMeasurement || len(line.tags) != 0 || line.timestamp != "" {
+		t.Errorf("got %+v; want the default measurement, no tags and no timestamp", line)
+	}
+	if _, ok := line.fields["rps"]; ok {
+		t.Errorf("fields = %v; want no NaN rps", line.fields)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"math"
+	"sort"
+)
+
+// p2Estimator estimates a percentile of a stream of values in constant
+// memory with the P² algorithm of Jain and Chlamtac: it keeps five
+// markers, the minimum, the maximum, the percentile and two middle
+// percentiles, whose heights are adjusted with a piecewise-parabolic
+// fit as the values arrive. It knows the minimum and the maximum
+// exactly, but the percentile is an estimate, usually within a few
+// percent on smooth distributions and less accurate on multimodal ones.
+type p2Estimator struct {
+	p float64
+	n int64
+	// heights are the heights of the markers, pos their positions and
+	// want their desired positions, which move by inc per value.
+	heights [5]float64
+	pos     [5]float64
+	want    [5]float64
+	inc     [5]float64
+}
+
+// newP2Estimator returns an estimator of the p-th percentile.
+func newP2Estimator(p float64) *p2Estimator {
+	p /= 100
+	return &p2Estimator{
+		p:    p,
+		pos:  [5]float64{1, 2, 3, 4, 5},
+		want: [5]float64{1, 1 + 2*p, 1 + 4*p, 3 + 2*p, 5},
+		inc:  [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+func (e *p2Estimator) add(v float64) {
+	e.n++
+	if e.n <= 5 {
+		e.heights[e.n-1] = v
+		if e.n == 5 {
+			sort.Float64s(e.heights[:])
+		}
+		return
+	}
+
+	// Find the cell k of v, heights[k] <= v < heights[k+1], and shift
+	// the markers above it.
+	var k int
+	switch {
+	case v < e.heights[0]:
+		e.heights[0] = v
+	case v >= e.heights[4]:
+		e.heights[4] = v
+		k = 3
+	default:
+		for v >= e.heights[k+1] {
+			k++
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		e.pos[i]++
+	}
+	for i := range e.want {
+		e.want[i] += e.inc[i]
+	}
+
+	// Move the middle markers that are off their desired position.
+	for i := 1; i < 4; i++ {
+		d := e.want[i] - e.pos[i]
+		if d >= 1 && e.pos[i+1]-e.pos[i] > 1 || d <= -1 && e.pos[i-1]-e.pos[i] < -1 {
+			d = math.Copysign(1, d)
+			h := e.parabolic(i, d)
+			if h <= e.heights[i-1] || h >= e.heights[i+1] {
+				h = e.linear(i, d)
+			}
+			e.heights[i] = h
+			e.pos[i] += d
+		}
+	}
+}
+
+func (e *p2Estimator) parabolic(i int, d float64) float64 {
+	q, n := e.heights, e.pos
+	return q[i] + d/(n[i+1]-n[i-1])*
+		((n[i]-n[i-1]+d)*(q[i+1]-q[i])/(n[i+1]-n[i])+
+			(n[i+1]-n[i]-d)*(q[i]-q[i-1])/(n[i]-n[i-1]))
+}
+
+func (e *p2Estimator) linear(i int, d float64) float64 {
+	j := i + int(d)
+	return e.heights[i] + d*(e.heights[j]-e.heights[i])/(e.pos[j]-e.pos[i])
+}
+
+// initial returns the first values, sorted, until there are five.
+func (e *p2Estimator) initial() []float64 {
+	if e.n >= 5 {
+		return e.heights[:]
+	}
+	v := make([]float64, e.n)
+	copy(v, e.heights[:e.n])
+	sort.Float64s(v)
+	return v
+}
+
+// value returns the estimate of the percentile. Of fewer than five
+// values, it is their exact nearest-rank percentile.
+func (e *p2Estimator) value() float64 {
+	if e.n >= 5 {
+		return e.heights[2]
+	}
+	return percentile(e.initial(), e.p*100, NearestRank)
+}
+
+func (e *p2Estimator) min() float64 {
+	if v := e.initial(); len(v) > 0 {
+		return v[0]
+	}
+	return 0
+}
+
+func (e *p2Estimator) max() float64 {
+	if v := e.initial(); len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return 0
+}
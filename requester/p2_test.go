@@ -0,0 +1,137 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestP2Estimator(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	const n = 100000
+	streams := map[string]func() float64{
+		"uniform":     rng.Float64,
+		"exponential": rng.ExpFloat64,
+		"normal":      func() float64 { return 10 + rng.NormFloat64() },
+	}
+	for name, next := range streams {
+		pctls := []float64{50, 90, 95, 99}
+		ests := make([]*p2Estimator, len(pctls))
+		for i, p := range pctls {
+			ests[i] = newP2Estimator(p)
+		}
+		data := make([]float64, n)
+		for i := range data {
+			data[i] = next()
+			for _, e := range ests {
+				e.add(data[i])
+			}
+		}
+		sort.Float64s(data)
+		for i, p := range pctls {
+			want := percentile(data, p, NearestRank)
+			if got := ests[i].value(); math.Abs(got-want) > 0.02*want {
+				t.Errorf("%s: p%v = %v; want %v within 2%%", name, p, got, want)
+			}
+		}
+		if e := ests[0]; e.min() != data[0] || e.max() != data[n-1] {
+			t.Errorf("%s: min, max = %v, %v; want %v, %v", name, e.min(), e.max(), data[0], data[n-1])
+		}
+	}
+}
+
+func TestP2EstimatorFewValues(t *testing.T) {
+	e := newP2Estimator(50)
+	if got := e.value(); got != 0 {
+		t.Errorf("value of no values = %v; want 0", got)
+	}
+	for _, v := range []float64{3, 1, 2} {
+		e.add(v)
+	}
+	if e.value() != 2 || e.min() != 1 || e.max() != 3 {
+		t.Errorf("value, min, max = %v, %v, %v; want 2, 1, 3", e.value(), e.min(), e.max())
+	}
+}
+
+func TestStreamPercentiles(t *testing.T) {
+	const n = 10000
+	r := newTestReport(n)
+	r.streamPercentiles()
+	results := make([]*result, n)
+	for i := range results {
+		// The durations are uniform over [1, n] ms.
+		results[i] = &result{statusCode: 200, duration: ms(float64(i*7919%n + 1))}
+	}
+	feed(r, results...)
+	r.finalize(time.Second)
+	s := r.snapshot()
+
+	if len(r.lats) != 0 {
+		t.Errorf("retained %d samples; want 0", len(r.lats))
+	}
+	if !approx(s.Average, (n+1)/2000.0) {
+		t.Errorf("Average = %v; want %v", s.Average, (n+1)/2000.0)
+	}
+	if s.Fastest != 0.001 || s.Slowest != n/1000.0 {
+		t.Errorf("Fastest, Slowest = %v, %v; want 0.001, %v", s.Fastest, s.Slowest, n/1000.0)
+	}
+	if want := len(defaultPercentiles) + len(defaultTailPercentiles); len(s.LatencyDistribution) != want {
+		t.Fatalf("got %d percentiles; want %d", len(s.LatencyDistribution), want)
+	}
+	for _, d := range s.LatencyDistribution {
+		want := d.Percentage / 100 * n / 1000
+		if math.Abs(d.Latency-want) > 0.02*want {
+			t.Errorf("p%v = %v; want %v within 2%%", d.Percentage, d.Latency, want)
+		}
+	}
+	if s.StatusCodeDist[200] != n {
+		t.Errorf("StatusCodeDist = %v; want %d 200s", s.StatusCodeDist, n)
+	}
+}
+
+func TestStreamPercentilesSummary(t *testing.T) {
+	r := newTestReport(100)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.summaryLine = true
+	r.streamPercentiles()
+	var results []*result
+	for i := 0; i < 100; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(20), connDuration: ms(5), contentLength: 10})
+	}
+	feed(r, results...)
+	r.finalize(time.Second)
+	out := buf.String()
+	// Without samples, these cannot be computed.
+	for _, line := range []string{"Geo. mean:", "IQ mean:", "Stddev:", "Response time histogram", "Latency by status code", "DNS-lookup, TLS handshake", "fastest, slowest"} {
+		if strings.Contains(out, line) {
+			t.Errorf("summary has a %q line:\n%s", line, out)
+		}
+	}
+	for _, want := range []string{"Size/request:\t10 B", "  50% in 0.0200 secs\n", "  DNS+dialup:\t\t0.0050 secs\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary does not have %q:\n%s", want, out)
+		}
+	}
+	if want := "SUMMARY requests=100 errors=0 rps=100.0 p50=0.0200 p99=0.0200"; !strings.Contains(out, want) {
+		t.Errorf("summary does not have %q:\n%s", want, out)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// reportPostAttempts is the number of attempts to post the report.
+	reportPostAttempts = 3
+	// reportPostTimeout is the timeout of each attempt.
+	reportPostTimeout = 10 * time.Second
+)
+
+// reportPostBackoff is the wait before the second attempt to post the
+// report. It grows linearly with the attempts.
+var reportPostBackoff = 500 * time.Millisecond
+
+// postReport posts rep as JSON to url, see finiteReport. Failed attempts
+// are retried, except on client errors, which would fail again.
+func postReport(url string, rep Report) error {
+	body, err := json.Marshal(finiteReport(rep))
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: reportPostTimeout}
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = postOnce(client, url, body)
+		if err == nil || !retry || attempt == reportPostAttempts {
+			break
+		}
+		time.Sleep(reportPostBackoff * time.Duration(attempt))
+	}
+	if err != nil {
+		return fmt.Errorf("posting the report to %s: %v", url, err)
+	}
+	return nil
+}
+
+// postOnce makes an attempt to post body to url. It reports whether a
+// failed attempt is worth retrying.
+func postOnce(client *http.Client, url string, body []byte) (bool, error) {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode >= 500, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
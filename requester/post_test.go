@@ -0,0 +1,151 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostReport(t *testing.T) {
+	var posted []byte
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		posted, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	r := newTestReport(3)
+	r.reportURL = server.URL
+	feed(r,
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(20)},
+		&result{statusCode: 500, duration: ms(30)},
+	)
+	if err := r.finalize(time.Second); err != nil {
+		t.Fatalf("finalize = %v", err)
+	}
+	want, err := json.Marshal(finiteReport(r.snapshot()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(posted, want) {
+		t.Errorf("posted:\n%s\nwant:\n%s", posted, want)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q; want application/json", contentType)
+	}
+}
+
+func TestPostReportNaN(t *testing.T) {
+	var posted []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	if err := postReport(server.URL, Report{NumRes: 1, Average: math.NaN(), Rps: math.Inf(1)}); err != nil {
+		t.Fatalf("postReport = %v", err)
+	}
+	var rep Report
+	if err := json.Unmarshal(posted, &rep); err != nil {
+		t.Fatalf("posted invalid JSON: %v\n%s", err, posted)
+	}
+	if rep.NumRes != 1 || rep.Average != 0 || rep.Rps != 0 {
+		t.Errorf("NumRes, Average, Rps = %d, %v, %v; want 1, 0, 0", rep.NumRes, rep.Average, rep.Rps)
+	}
+}
+
+func TestPostReportError(t *testing.T) {
+	defer func(d time.Duration) { reportPostBackoff = d }(reportPostBackoff)
+	reportPostBackoff = 0
+
+	tests := []struct {
+		status   int
+		attempts int32
+	}{
+		{http.StatusServiceUnavailable, reportPostAttempts},
+		{http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			w.WriteHeader(tt.status)
+		}))
+		r := newTestReport(1)
+		r.reportURL = server.URL
+		feed(r, &result{statusCode: 200, duration: ms(10)})
+		err := r.finalize(time.Second)
+		server.Close()
+		if err == nil || !strings.Contains(err.Error(), http.StatusText(tt.status)) {
+			t.Errorf("status %d: finalize = %v; want an error with the status", tt.status, err)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("status %d: %d attempts; want %d", tt.status, attempts, tt.attempts)
+		}
+	}
+
+	// A response after a failed attempt succeeds.
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	r := newTestReport(1)
+	r.reportURL = server.URL
+	feed(r, &result{statusCode: 200, duration: ms(10)})
+	if err := r.finalize(time.Second); err != nil || attempts != 2 {
+		t.Errorf("finalize = %v after %d attempts; want nil after 2", err, attempts)
+	}
+}
+
+func TestPostReportRetryNaN(t *testing.T) {
+	defer func(d time.Duration) { reportPostBackoff = d }(reportPostBackoff)
+	reportPostBackoff = 0
+
+	// Every attempt posts valid JSON, also after a failed one.
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	if err := postReport(server.URL, Report{Average: math.NaN(), MeanCIHigh: math.Inf(-1)}); err != nil {
+		t.Fatalf("postReport = %v", err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("%d attempts; want 2", len(bodies))
+	}
+	for i, body := range bodies {
+		if !json.Valid(body) {
+			t.Errorf("attempt %d posted invalid JSON:\n%s", i+1, body)
+		}
+	}
+}
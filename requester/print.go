@@ -13,24 +13,71 @@
 // limitations under the License.
 
 /*
-Hey supports two output formats: summary and CSV
+Hey supports nine output formats: summary, CSV, JSON, YAML, NDJSON, Prometheus, InfluxDB, HTML and sketch
 
 The summary output presents a number of statistics about the requests in a
 human-readable format, including:
-- general statistics: requests/second, total runtime, and average, fastest, and slowest requests.
+- general statistics: requests/second, total runtime, start and end time, and average, fastest, and slowest requests.
 - a response time histogram.
-- a percentile latency distribution.
+- a percentile latency distribution, broken down by the stages of the requests.
 - statistics (average, fastest, slowest) on the stages of the requests.
+- a breakdown of the average request into its stages, drawn as a stacked bar.
 
-The comma-separated CSV format is proceeded by a header, and consists of the following columns:
+The compact variant of the summary lists the general statistics, the
+percentiles of the response time and the status codes one per line, to fit
+narrow terminals. The markdown variant renders the general statistics, the
+latency distribution, the status codes and the errors as GitHub-flavored
+Markdown tables, e.g. to paste into issues.
+
+The comma-separated CSV format is written as the results arrive. It is
+proceeded by a header, and consists of one row per successful request
+with the following columns:
 1. response-time:	Total time taken for request (in seconds)
 2. DNS+dialup:		Time taken to establish the TCP connection (in seconds)
 3. DNS:				Time taken to do the DNS lookup (in seconds)
-4. Request-write:	Time taken to write full request (in seconds)
-5. Response-delay: 	Time taken to first byte received (in seconds)
-6. Response-read:	Time taken to read full response (in seconds)
-7. status-code:		HTTP status code of the response (e.g. 200)
-8. offset:			The time since the start of the benchmark when the request was started. (in seconds)
+4. TLS-handshake:	Time taken to do the TLS handshake (in seconds)
+5. Request-write:	Time taken to write full request (in seconds)
+6. Response-delay: 	Time taken to first byte received (in seconds)
+7. Response-read:	Time taken to read full response (in seconds)
+8. status-code:		HTTP status code of the response (e.g. 200)
+9. bytes:			Content length of the response (in bytes)
+10. offset:			The time since the start of the benchmark when the request was started. (in seconds)
+
+The JSON format is the Report struct encoded as an indented JSON object,
+including the per-request latency slices, the histogram buckets and the
+status code distribution. Latencies are in seconds and Total is in nanoseconds.
+Statistics that are undefined, e.g. NaN, are written as 0.
+
+The YAML format is the Report struct with the same fields as the JSON
+format, except that Total is a duration string such as 1m30s.
+
+The newline-delimited NDJSON format is written as the results arrive and
+consists of one JSON object per request, including the failed ones, with
+the following keys: offset, response_time, dns_dialup, dns, tls_handshake,
+request_write, response_delay, response_read, status_code, bytes and error.
+Times are in seconds. Optionally, the last line is the Report struct
+encoded as a JSON object.
+
+The Prometheus format is the text exposition format, suitable for the
+textfile collector of the node exporter. It consists of the following metrics:
+- hey_requests_total:			Number of requests made.
+- hey_request_duration_seconds:	Summary of the response times of the successful requests.
+- hey_errors_total:				Number of failed requests, by error.
+- hey_status_codes_total:		Number of responses, by status code.
+
+The InfluxDB format is a single line of the line protocol, of the hey
+measurement by default, timestamped with the end of the run. Its fields are
+requests and errors, as integers, and rps, error_rate, average, fastest,
+slowest and the percentiles of the latency distribution, e.g. p99, in
+seconds.
+
+The HTML format is a self-contained page with the summary, charts of the
+response time histogram and of the latency over time, the latency
+distribution, the status code distribution and the errors.
+
+The sketch format is the binary encoded latency sketch of the report, see
+Report.LatencySketch. The sketches of the runs on several machines can be
+merged to compute the percentiles of the combined latencies.
 */
 package requester
 
@@ -38,26 +85,108 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
-func newTemplate(output string) *template.Template {
+// newTemplate parses the template of the output. The funcs are added to,
+// and override, the default template functions.
+func newTemplate(output string, funcs template.FuncMap) *template.Template {
 	outputTmpl := output
 	switch outputTmpl {
 	case "":
 		outputTmpl = defaultTmpl
-	case "csv":
-		outputTmpl = csvTmpl
+	case "compact":
+		outputTmpl = compactTmpl
+	case "markdown":
+		outputTmpl = markdownTmpl
 	}
-	return template.Must(template.New("tmpl").Funcs(tmplFuncMap).Parse(outputTmpl))
+	return template.Must(template.New("tmpl").Funcs(tmplFuncMap).Funcs(funcs).Parse(outputTmpl))
 }
 
 var tmplFuncMap = template.FuncMap{
 	"formatNumber":    formatNumber,
 	"formatNumberInt": formatNumberInt,
-	"histogram":       histogram,
+	"histogram":       histogramFunc(defaultBarWidth, Seconds),
+	"phaseStack":      phaseStackFunc(defaultBarWidth, Seconds),
+	"latency":         Seconds.format,
+	"unit":            Seconds.suffix,
 	"jsonify":         jsonify,
+	"ms":              formatMillis,
+	"bytes":           formatBytes,
+	"pct":             formatPercent,
+	"markdownCell":    markdownCell,
+	"timestamp":       formatTimestamp,
+}
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// finiteReport returns a copy of rep with its NaN and infinite floats
+// set to 0, since JSON cannot encode them. The slices, maps and pointers
+// of rep are copied too, they share storage with the caller's Report.
+func finiteReport(rep Report) Report {
+	return scrubFloats(reflect.ValueOf(rep)).Interface().(Report)
+}
+
+// scrubFloats returns a deep copy of v with its non-finite floats set to 0.
+func scrubFloats(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if f := v.Float(); math.IsNaN(f) || math.IsInf(f, 0) {
+			return reflect.Zero(v.Type())
+		}
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				c.Field(i).Set(scrubFloats(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(scrubFloats(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(scrubFloats(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), scrubFloats(iter.Value()))
+		}
+		return c
+	case reflect.Pointer:
+		if !v.IsNil() {
+			c := reflect.New(v.Type().Elem())
+			c.Elem().Set(scrubFloats(v.Elem()))
+			return c
+		}
+	}
+	return v
 }
 
 func jsonify(v interface{}) string {
@@ -65,6 +194,11 @@ func jsonify(v interface{}) string {
 	return string(d)
 }
 
+// markdownCell escapes s to fit in a cell of a Markdown table.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
 func formatNumber(duration float64) string {
 	return fmt.Sprintf("%4.4f", duration)
 }
@@ -73,7 +207,93 @@ func formatNumberInt(duration int) string {
 	return fmt.Sprintf("%d", duration)
 }
 
-func histogram(buckets []Bucket) string {
+// formatMillis formats a duration in seconds as milliseconds.
+func formatMillis(seconds float64) string {
+	return fmt.Sprintf("%.2f ms", seconds*1000)
+}
+
+// formatBytes formats a size with binary prefixes, e.g. 1.5 KiB.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit && size > -unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	v := float64(size)
+	var i int
+	for ; (v >= unit || v <= -unit) && i < len(bytePrefixes); i++ {
+		v /= unit
+	}
+	return fmt.Sprintf("%.1f %siB", v, bytePrefixes[i-1:i])
+}
+
+const bytePrefixes = "KMGTPE"
+
+// formatPercent formats a fraction as a percentage, e.g. 12.50%.
+func formatPercent(fraction float64) string {
+	return fmt.Sprintf("%.2f%%", fraction*100)
+}
+
+// formatTimestamp formats t as RFC 3339, e.g. 2006-01-02T15:04:05Z, or
+// as the empty string if it is zero.
+func formatTimestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// defaultBarWidth is the length of the longest bar of the histogram.
+const defaultBarWidth = 40
+
+// LatencyUnit is the unit of the latencies in the summary. It only
+// affects the display, the values of the Report are in seconds.
+type LatencyUnit int
+
+const (
+	Seconds LatencyUnit = iota
+	Milliseconds
+	Microseconds
+)
+
+// scale returns the number of units in a second.
+func (u LatencyUnit) scale() float64 {
+	switch u {
+	case Milliseconds:
+		return 1e3
+	case Microseconds:
+		return 1e6
+	}
+	return 1
+}
+
+// suffix returns the suffix of the latencies in the unit.
+func (u LatencyUnit) suffix() string {
+	switch u {
+	case Milliseconds:
+		return "ms"
+	case Microseconds:
+		return "µs"
+	}
+	return "secs"
+}
+
+// format formats a latency in seconds in the unit, with its suffix.
+func (u LatencyUnit) format(seconds float64) string {
+	return formatNumber(seconds*u.scale()) + " " + u.suffix()
+}
+
+// histogramFunc returns a template function that draws the histogram
+// with a longest bar of width characters, and marks in the unit.
+func histogramFunc(width int, unit LatencyUnit) func([]Bucket) string {
+	if width < 1 {
+		width = defaultBarWidth
+	}
+	return func(buckets []Bucket) string {
+		return histogram(buckets, width, unit.scale())
+	}
+}
+
+func histogram(buckets []Bucket, width int, scale float64) string {
 	max := 0
 	for _, b := range buckets {
 		if v := b.Count; v > max {
@@ -85,48 +305,346 @@ func histogram(buckets []Bucket) string {
 		// Normalize bar lengths.
 		var barLen int
 		if max > 0 {
-			barLen = (buckets[i].Count*40 + max/2) / max
+			barLen = (buckets[i].Count*width + max/2) / max
 		}
-		res.WriteString(fmt.Sprintf("  %4.3f [%v]\t|%v\n", buckets[i].Mark, buckets[i].Count, strings.Repeat(barChar, barLen)))
+		mark := fmt.Sprintf("%4.3f", buckets[i].Mark*scale)
+		if buckets[i].Overflow && i > 0 {
+			mark = fmt.Sprintf(">%4.3f", buckets[i-1].Mark*scale)
+		}
+		res.WriteString(fmt.Sprintf("  %s [%v]\t|%v\n", mark, buckets[i].Count, strings.Repeat(barChar, barLen)))
 	}
 	return res.String()
 }
 
+// phaseSegment is a phase of the requests in the phase breakdown.
+type phaseSegment struct {
+	Name    string
+	Average float64
+	// Offset and Width are the start and the length of the segment in
+	// the bar.
+	Offset, Width int
+}
+
+// phaseSegments splits a bar of width characters between the phases of
+// the requests, in proportion to their average durations. The widths of
+// the segments add up to width.
+func phaseSegments(rep Report, width int) []phaseSegment {
+	// The connection setup includes the DNS lookup and the TLS handshake.
+	dialup := max(rep.AvgConn-rep.AvgDNS-rep.AvgTLS, 0)
+	segments := []phaseSegment{
+		{Name: "DNS-lookup", Average: rep.AvgDNS},
+		{Name: "dialup", Average: dialup},
+		{Name: "TLS handshake", Average: rep.AvgTLS},
+		{Name: "req write", Average: rep.AvgReq},
+		{Name: "resp wait", Average: rep.AvgDelay},
+		{Name: "resp read", Average: rep.AvgRes},
+	}
+	var total float64
+	for _, s := range segments {
+		total += s.Average
+	}
+	if total <= 0 {
+		return segments
+	}
+	// Round the ends of the segments rather than their widths, so that
+	// the rounding errors do not add up.
+	var sum float64
+	for i := range segments {
+		sum += segments[i].Average
+		end := int(math.Round(sum / total * float64(width)))
+		if i > 0 {
+			segments[i].Offset = segments[i-1].Offset + segments[i-1].Width
+		}
+		segments[i].Width = end - segments[i].Offset
+	}
+	return segments
+}
+
+// phaseStackFunc returns a template function that draws the average
+// durations of the phases of the requests as consecutive segments of a
+// bar of width characters.
+func phaseStackFunc(width int, unit LatencyUnit) func(Report) string {
+	if width < 1 {
+		width = defaultBarWidth
+	}
+	return func(rep Report) string {
+		res := new(bytes.Buffer)
+		for _, s := range phaseSegments(rep, width) {
+			fmt.Fprintf(res, "  %-14s|%s%s%s| %s\n", s.Name, strings.Repeat(" ", s.Offset),
+				strings.Repeat(barChar, s.Width), strings.Repeat(" ", width-s.Offset-s.Width), unit.format(s.Average))
+		}
+		return res.String()
+	}
+}
+
 var (
 	defaultTmpl = `
-Summary:
-  Total:	{{ formatNumber .Total.Seconds }} secs
-  Slowest:	{{ formatNumber .Slowest }} secs
-  Fastest:	{{ formatNumber .Fastest }} secs
-  Average:	{{ formatNumber .Average }} secs
+Summary:{{ if .Partial }} (partial, the run was interrupted){{ end }}
+  Total:	{{ formatNumber .Total.Seconds }} secs{{ if not .StartTime.IsZero }}
+  Start:	{{ timestamp .StartTime }}
+  End:	{{ timestamp .EndTime }}{{ end }}
+  Slowest:	{{ latency .Slowest }}
+  Fastest:	{{ latency .Fastest }}
+  Average:	{{ latency .Average }}{{ if .MeanCIHigh }} (95% CI: {{ latency .MeanCILow }}–{{ latency .MeanCIHigh }}){{ end }}{{ if .Lats }}
+  Geo. mean:	{{ latency .GeoMean }}
+  IQ mean:	{{ latency .IQMean }}{{ end }}
+  Median:	{{ latency .Median }}{{ if .P95CIHigh }}
+  p95 95% CI:	{{ latency .P95CILow }}–{{ latency .P95CIHigh }}{{ end }}{{ if .Lats }}
+  Stddev:	{{ latency .Stddev }}{{ end }}
   Requests/sec:	{{ formatNumber .Rps }}
+  Goodput:	{{ formatNumber .Goodput }} successful responses/sec{{ if gt .TargetRps 0.0 }}
+  Target:	{{ formatNumber .TargetRps }} requests/sec, {{ formatNumber .RpsAchievedPct }}% achieved{{ if lt .RpsAchievedPct 90.0 }}
+  WARNING:	the target rate was not reached, the target may not keep up{{ end }}{{ end }}{{ if gt .NumWarmup 0 }}
+  Warmup:	{{ .NumWarmup }} requests discarded{{ end }}
+  Connections:	{{ .ConnNew }} new, {{ .ConnReused }} reused{{ if .DistinctConnections }}, {{ .DistinctConnections }} distinct{{ end }}{{ if .PeakConcurrency }}
+  Concurrency:	{{ .PeakConcurrency }} peak, {{ formatNumber .AvgConcurrency }} average{{ end }}{{ if gt .ApdexT 0.0 }}
+  Apdex:	{{ formatNumber .Apdex }} (T = {{ latency .ApdexT }}){{ end }}{{ if gt .DroppedCount 0 }}
+  Sampled:	{{ .SampledCount }} responses, the latencies of {{ .DroppedCount }} more are not part of the statistics{{ end }}
   {{ if gt .SizeTotal 0 }}
-  Total data:	{{ .SizeTotal }} bytes
-  Size/request:	{{ .SizeReq }} bytes{{ end }}
-
-Response time histogram:
-{{ histogram .Histogram }}
+  Total data:	{{ bytes .SizeTotal }}
+  Size/request:	{{ bytes .SizeReq }}
+  Smallest:	{{ bytes .SizeMin }}
+  Largest:	{{ bytes .SizeMax }}{{ end }}{{ if gt .ReqSizeTotal 0 }}
+  Request data:	{{ bytes .ReqSizeTotal }}{{ end }}{{ if gt .MBPerSec 0.0 }}
+  Transfer rate:	{{ formatNumber .MBPerSec }} MB/s{{ end }}
+{{ if .SLOResults }}
+SLO:{{ range .SLOResults }}
+  [{{ if .Passed }}pass{{ else }}FAIL{{ end }}]	{{ .Rule }} ({{ if .NoLatencies }}no latencies{{ else }}actual {{ formatNumber .Actual }}{{ end }}){{ end }}
+{{ end }}{{ if .Lats }}
+Response time histogram{{ if ne unit "secs" }} ({{ unit }}){{ end }}:
+{{ histogram .Histogram }}{{ if .Bimodal }}  Bimodal, peaks in {{ range $i, $r := .ModalRanges }}{{ if $i }} and {{ end }}{{ latency (index $r 0) }}–{{ latency (index $r 1) }}{{ end }}
+{{ end }}
+{{ end }}
+Latency distribution{{ if .Lats }} (total, DNS+dialup, DNS-lookup, TLS handshake, req write, resp wait, resp read, TTFB){{ end }}:{{ range .LatencyDistribution }}
+  {{ .Percentage }}% in {{ latency .Latency }}{{ if $.Lats }}, {{ latency .ConnLatency }}, {{ latency .DnsLatency }}, {{ latency .TlsLatency }}, {{ latency .ReqLatency }}, {{ latency .DelayLatency }}, {{ latency .RespLatency }}, {{ latency .TtfbLatency }}{{ end }}{{ end }}
 
-Latency distribution:{{ range .LatencyDistribution }}
-  {{ .Percentage }}%% in {{ formatNumber .Latency }} secs{{ end }}
-
-Details (average, fastest, slowest):
-  DNS+dialup:		{{ formatNumber .AvgConn }} secs, {{ formatNumber .ConnMax }} secs, {{ formatNumber .ConnMin }} secs
-  DNS-lookup:		{{ formatNumber .AvgDNS }} secs, {{ formatNumber .DnsMax }} secs, {{ formatNumber .DnsMin }} secs
-  TLS handshake:	{{ formatNumber .AvgTLS }} secs, {{ formatNumber .TlsMax }} secs, {{ formatNumber .TlsMin }} secs
-  req write:		{{ formatNumber .AvgReq }} secs, {{ formatNumber .ReqMax }} secs, {{ formatNumber .ReqMin }} secs
-  resp wait:		{{ formatNumber .AvgDelay }} secs, {{ formatNumber .DelayMax }} secs, {{ formatNumber .DelayMin }} secs
-  resp read:		{{ formatNumber .AvgRes }} secs, {{ formatNumber .ResMax }} secs, {{ formatNumber .ResMin }} secs
+Details (average{{ if .Lats }}, fastest, slowest{{ end }}):
+  DNS+dialup:		{{ latency .AvgConn }}{{ if .Lats }}, {{ latency .ConnMin }}, {{ latency .ConnMax }}{{ end }}
+  DNS-lookup:		{{ latency .AvgDNS }}{{ if .Lats }}, {{ latency .DnsMin }}, {{ latency .DnsMax }}{{ end }}
+  TLS handshake:	{{ latency .AvgTLS }}{{ if .Lats }}, {{ latency .TlsMin }}, {{ latency .TlsMax }}{{ end }}
+  req write:		{{ latency .AvgReq }}{{ if .Lats }}, {{ latency .ReqMin }}, {{ latency .ReqMax }}{{ end }}
+  resp wait:		{{ latency .AvgDelay }}{{ if .Lats }}, {{ latency .DelayMin }}, {{ latency .DelayMax }}{{ end }}
+  resp read:		{{ latency .AvgRes }}{{ if .Lats }}, {{ latency .ResMin }}, {{ latency .ResMax }}{{ end }}
+  TTFB:			{{ latency .AvgTTFB }}{{ if .Lats }}, {{ latency .TtfbMin }}, {{ latency .TtfbMax }}{{ end }}
 
+Phase breakdown (average):
+{{ phaseStack . }}
 Status code distribution:{{ range $code, $num := .StatusCodeDist }}
   [{{ $code }}]	{{ $num }} responses{{ end }}
-
+{{ if .StatusLatencies }}
+Latency by status code (average, 95th percentile):{{ range $code, $lat := .StatusLatencies }}
+  [{{ $code }}]	{{ latency $lat.Average }}, {{ latency $lat.P95 }}{{ end }}
+{{ end }}
 {{ if gt (len .ErrorDist) 0 }}Error distribution:{{ range $err, $num := .ErrorDist }}
-  [{{ $num }}]	{{ $err }}{{ end }}{{ end }}
+  [{{ $num }}]	{{ $err }}{{ end }}
+
+Error categories:{{ range $category, $num := .ErrorCategoryDist }}
+  [{{ $num }}]	{{ $category }}{{ end }}{{ end }}
 `
-	csvTmpl = `{{ $connLats := .ConnLats }}{{ $dnsLats := .DnsLats }}{{ $dnsLats := .DnsLats }}{{ $tlsLats := .TlsLats }}{{ $reqLats := .ReqLats }}{{ $delayLats := .DelayLats }}{{ $resLats := .ResLats }}{{ $statusCodeLats := .StatusCodes }}{{ $offsets := .Offsets -}}
-response-time,DNS+dialup,DNS,TLS-handshake,Request-write,Response-delay,Response-read,status-code,offset
-{{- range $i, $v := .Lats }}
-{{ formatNumber $v }},{{ formatNumber (index $connLats $i) }},{{ formatNumber (index $dnsLats $i) }},{{ formatNumber (index $tlsLats $i) }},{{ formatNumber (index $reqLats $i) }},{{ formatNumber (index $delayLats $i) }},{{ formatNumber (index $resLats $i) }},{{ formatNumberInt (index $statusCodeLats $i) }},{{ formatNumber (index $offsets $i) }}
-{{- end -}}`
+
+	// noResponsesTmpl replaces the summary if no response succeeded.
+	noResponsesTmpl = `
+Summary:{{ if .Partial }} (partial, the run was interrupted){{ end }}
+  Total:	{{ formatNumber .Total.Seconds }} secs
+  Requests:	{{ .NumRes }}
+  Errors:	{{ .NumErrs }}
+
+0 successful responses{{ if gt (len .ErrorDist) 0 }}; see error distribution below.
+
+Error distribution:{{ range $err, $num := .ErrorDist }}
+  [{{ $num }}]	{{ $err }}{{ end }}
+
+Error categories:{{ range $category, $num := .ErrorCategoryDist }}
+  [{{ $num }}]	{{ $category }}{{ end }}{{ else }}.{{ end }}
+`
+
+	// compactTmpl fits narrow terminals, with one value per line.
+	compactTmpl = `
+Summary:{{ if .Partial }} (partial){{ end }}
+  Requests:   {{ .NumRes }}
+  Errors:     {{ .NumErrs }}
+  Total:      {{ formatNumber .Total.Seconds }} secs
+  Req/sec:    {{ formatNumber .Rps }}
+  Fastest:    {{ latency .Fastest }}
+  Slowest:    {{ latency .Slowest }}
+  Average:    {{ latency .Average }}
+
+Latency:{{ range .LatencyDistribution }}
+  {{ printf "%-11s" (printf "p%v:" .Percentage) }} {{ latency .Latency }}{{ end }}
+
+Status codes:{{ range $code, $num := .StatusCodeDist }}
+  [{{ $code }}]       {{ $num }}{{ end }}
+`
+
+	// markdownTmpl renders the summary as GitHub-flavored Markdown tables.
+	markdownTmpl = `
+### Summary{{ if .Partial }} (partial, the run was interrupted){{ end }}
+
+| Metric | Value |
+| --- | ---: |
+| Requests | {{ .NumRes }} |
+| Errors | {{ .NumErrs }} |
+| Total | {{ formatNumber .Total.Seconds }} secs |
+| Requests/sec | {{ formatNumber .Rps }} |
+| Fastest | {{ latency .Fastest }} |
+| Average | {{ latency .Average }} |
+| Median | {{ latency .Median }} |
+| Slowest | {{ latency .Slowest }} |
+| Total data | {{ bytes .SizeTotal }} |
+
+### Latency distribution
+
+| Percentile | Latency |
+| ---: | ---: |
+{{ range .LatencyDistribution }}| p{{ .Percentage }} | {{ latency .Latency }} |
+{{ end }}
+### Status code distribution
+
+| Status code | Responses |
+| ---: | ---: |
+{{ range $code, $num := .StatusCodeDist }}| {{ $code }} | {{ $num }} |
+{{ end }}{{ if .ErrorDist }}
+### Error distribution
+
+| Errors | Message |
+| ---: | --- |
+{{ range $err, $num := .ErrorDist }}| {{ $num }} | {{ markdownCell $err }} |
+{{ end }}{{ end }}`
 )
+
+const csvHeader = "response-time,DNS+dialup,DNS,TLS-handshake,Request-write,Response-delay,Response-read,status-code,bytes,offset\n"
+
+// writeCSVRow writes res to w as a row of the CSV output.
+func writeCSVRow(w io.Writer, res *result) {
+	size := res.contentLength
+	if size < 0 {
+		size = 0
+	}
+	fmt.Fprintf(w, "%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%4.4f,%d,%d,%4.4f\n",
+		res.duration.Seconds(),
+		res.connDuration.Seconds(),
+		res.dnsDuration.Seconds(),
+		res.tlsDuration.Seconds(),
+		res.reqDuration.Seconds(),
+		res.delayDuration.Seconds(),
+		res.resDuration.Seconds(),
+		res.statusCode,
+		size,
+		res.offset.Seconds())
+}
+
+// ndjsonRow is a line of the NDJSON output.
+type ndjsonRow struct {
+	Offset        float64 `json:"offset"`
+	ResponseTime  float64 `json:"response_time"`
+	DNSDialup     float64 `json:"dns_dialup"`
+	DNS           float64 `json:"dns"`
+	TLSHandshake  float64 `json:"tls_handshake"`
+	RequestWrite  float64 `json:"request_write"`
+	ResponseDelay float64 `json:"response_delay"`
+	ResponseRead  float64 `json:"response_read"`
+	StatusCode    int     `json:"status_code,omitempty"`
+	Bytes         int64   `json:"bytes,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// writeNDJSONRow writes res to w as a line of the NDJSON output.
+func writeNDJSONRow(w io.Writer, res *result) {
+	row := ndjsonRow{
+		Offset:        res.offset.Seconds(),
+		ResponseTime:  res.duration.Seconds(),
+		DNSDialup:     res.connDuration.Seconds(),
+		DNS:           res.dnsDuration.Seconds(),
+		TLSHandshake:  res.tlsDuration.Seconds(),
+		RequestWrite:  res.reqDuration.Seconds(),
+		ResponseDelay: res.delayDuration.Seconds(),
+		ResponseRead:  res.resDuration.Seconds(),
+		StatusCode:    res.statusCode,
+	}
+	if res.contentLength > 0 {
+		row.Bytes = res.contentLength
+	}
+	if res.err != nil {
+		row.Error = res.err.Error()
+	}
+	json.NewEncoder(w).Encode(row)
+}
+
+// String returns a summary of the key metrics of the report on a few
+// lines, e.g. for logging. Latencies are in seconds.
+func (rep Report) String() string {
+	return fmt.Sprintf("Requests:     %d (%d errors)\n"+
+		"Requests/sec: %s\n"+
+		"Average:      %s\n"+
+		"p50/p95/p99:  %s / %s / %s\n"+
+		"Slowest:      %s\n",
+		rep.NumRes, rep.NumErrs, formatNumber(rep.Rps), formatNumber(rep.Average),
+		formatNumber(reportPercentile(rep, 50)),
+		formatNumber(reportPercentile(rep, 95)),
+		formatNumber(reportPercentile(rep, 99)),
+		formatNumber(rep.Slowest))
+}
+
+// summaryField is a key and a value of the structured summary.
+type summaryField struct {
+	key   string
+	value float64
+}
+
+// summaryFields returns the key metrics of the report, in the order of
+// the structured summary.
+func summaryFields(rep Report) []summaryField {
+	rep = finiteReport(rep)
+	return []summaryField{
+		{"requests", float64(rep.NumRes)},
+		{"errors", float64(rep.NumErrs)},
+		{"error_rate", rep.ErrorRate},
+		{"total", rep.Total.Seconds()},
+		{"rps", rep.Rps},
+		{"goodput", rep.Goodput},
+		{"average", rep.Average},
+		{"fastest", rep.Fastest},
+		{"slowest", rep.Slowest},
+		{"p50", reportPercentile(rep, 50)},
+		{"p90", reportPercentile(rep, 90)},
+		{"p99", reportPercentile(rep, 99)},
+	}
+}
+
+// writeStructuredSummary writes the key metrics of the report to w as a
+// single line, of "key=value" pairs if format is "logfmt", or as a JSON
+// object if it is "json". The keys are always in the same order, and
+// latencies in seconds.
+func writeStructuredSummary(w io.Writer, format string, rep Report) error {
+	fields := summaryFields(rep)
+	var buf bytes.Buffer
+	switch format {
+	case "logfmt":
+		buf.WriteString("msg=summary")
+		for _, f := range fields {
+			fmt.Fprintf(&buf, " %s=%s", f.key, strconv.FormatFloat(f.value, 'f', -1, 64))
+		}
+	case "json":
+		buf.WriteString(`{"msg":"summary"`)
+		for _, f := range fields {
+			fmt.Fprintf(&buf, `,%q:%s`, f.key, strconv.FormatFloat(f.value, 'f', -1, 64))
+		}
+		buf.WriteString("}")
+	default:
+		return fmt.Errorf("unknown summary format %q, want logfmt or json", format)
+	}
+	buf.WriteString("\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// summaryLine returns a single line summary of the report, in a stable
+// format that is easy to find and parse in logs.
+func summaryLine(rep Report) string {
+	var errors int
+	for _, n := range rep.ErrorDist {
+		errors += n
+	}
+	return fmt.Sprintf("SUMMARY requests=%d errors=%d rps=%.1f p50=%.4f p99=%.4f",
+		rep.NumRes, errors, rep.Rps, reportPercentile(rep, 50), reportPercentile(rep, 99))
+}
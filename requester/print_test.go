@@ -0,0 +1,650 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
+// checkGolden compares got with the golden file testdata/name, or updates
+// the file if the -update flag is set.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s; got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestPrintJSON(t *testing.T) {
+	r := newTestReport(3)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "json"
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), connDuration: ms(1), tlsDuration: ms(2), contentLength: 100},
+		&result{statusCode: 404, duration: ms(20), connDuration: ms(2), tlsDuration: ms(1), contentLength: 50},
+		&result{statusCode: 200, duration: ms(30), connDuration: ms(3), tlsDuration: ms(3), contentLength: 150},
+	)
+	r.finalize(time.Second)
+
+	var got Report
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	again := &bytes.Buffer{}
+	if err := writeJSON(again, got); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != buf.String() {
+		t.Errorf("round-tripped report differs:\ngot  %s\nwant %s", again, buf)
+	}
+	if got.Total != time.Second {
+		t.Errorf("Total = %v; want 1s", got.Total)
+	}
+	if got.StatusCodeDist[200] != 2 || got.StatusCodeDist[404] != 1 {
+		t.Errorf("StatusCodeDist = %v; want 2x200, 1x404", got.StatusCodeDist)
+	}
+	if len(got.Lats) != 3 || len(got.Histogram) == 0 {
+		t.Errorf("got %d latencies and %d buckets; want 3 and > 0", len(got.Lats), len(got.Histogram))
+	}
+}
+
+func TestPrintJSONNoResponses(t *testing.T) {
+	r := newTestReport(2)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "json"
+	feed(r,
+		&result{err: errors.New("connection refused")},
+		&result{err: errors.New("connection refused")},
+	)
+	r.finalize(0)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if got["NumErrs"] != 2.0 || got["Average"] != 0.0 {
+		t.Errorf("NumErrs, Average = %v, %v; want 2, 0", got["NumErrs"], got["Average"])
+	}
+}
+
+func TestFiniteReport(t *testing.T) {
+	orig := Report{
+		Average:             math.NaN(),
+		Rps:                 math.Inf(1),
+		Fastest:             0.5,
+		LatencyDistribution: []LatencyDistribution{{Percentage: 50, Latency: math.NaN()}},
+		StatusLatencies:     map[int]StatusLatency{200: {Average: math.Inf(-1)}},
+		ModeBucketRange:     [2]float64{math.NaN(), 1},
+	}
+	rep := finiteReport(orig)
+	if _, err := json.Marshal(rep); err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	if rep.Average != 0 || rep.Rps != 0 || rep.Fastest != 0.5 {
+		t.Errorf("Average, Rps, Fastest = %v, %v, %v; want 0, 0, 0.5", rep.Average, rep.Rps, rep.Fastest)
+	}
+	if rep.LatencyDistribution[0].Latency != 0 || rep.StatusLatencies[200].Average != 0 || rep.ModeBucketRange != [2]float64{0, 1} {
+		t.Errorf("nested floats were not scrubbed: %+v", rep)
+	}
+	if !math.IsNaN(orig.LatencyDistribution[0].Latency) || !math.IsInf(orig.StatusLatencies[200].Average, -1) {
+		t.Errorf("the original report was scrubbed: %+v", orig)
+	}
+}
+
+func TestPrintCSV(t *testing.T) {
+	r := newTestReport(3)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "csv"
+	feed(r,
+		&result{statusCode: 200, duration: ms(12.5), connDuration: ms(2), dnsDuration: ms(1), tlsDuration: ms(3), reqDuration: ms(0.5), delayDuration: ms(6), resDuration: ms(1), contentLength: 512, offset: ms(100)},
+		&result{err: errors.New("connection refused"), offset: ms(150)},
+		&result{statusCode: 503, duration: ms(4), delayDuration: ms(3), contentLength: -1, offset: ms(1500)},
+	)
+	r.finalize(2 * time.Second)
+
+	want := "response-time,DNS+dialup,DNS,TLS-handshake,Request-write,Response-delay,Response-read,status-code,bytes,offset\n" +
+		"0.0125,0.0020,0.0010,0.0030,0.0005,0.0060,0.0010,200,512,0.1000\n" +
+		"0.0040,0.0000,0.0000,0.0000,0.0000,0.0030,0.0000,503,0,1.5000\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got CSV:\n%s\nwant:\n%s", got, want)
+	}
+	if len(r.lats) != 0 {
+		t.Errorf("CSV output retained %d samples; want 0", len(r.lats))
+	}
+}
+
+func TestPrintNDJSON(t *testing.T) {
+	for _, summary := range []bool{false, true} {
+		r := newTestReport(3)
+		buf := &bytes.Buffer{}
+		r.w = buf
+		r.output = "ndjson"
+		r.streamSummary = summary
+		feed(r,
+			&result{statusCode: 200, duration: ms(10), delayDuration: ms(8), contentLength: 64, offset: ms(5)},
+			&result{err: errors.New("connection refused"), duration: ms(1), offset: ms(6)},
+			&result{statusCode: 500, duration: ms(20), offset: ms(7)},
+		)
+		if !summary && len(r.lats) != 0 {
+			t.Errorf("streaming retained %d samples; want 0", len(r.lats))
+		}
+		r.finalize(time.Second)
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		wantLines := 3
+		if summary {
+			wantLines++
+		}
+		if len(lines) != wantLines {
+			t.Fatalf("summary=%v: got %d lines; want %d:\n%s", summary, len(lines), wantLines, buf)
+		}
+		var rows []ndjsonRow
+		for _, line := range lines[:3] {
+			var row ndjsonRow
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				t.Fatalf("line %q is not valid JSON: %v", line, err)
+			}
+			rows = append(rows, row)
+		}
+		if rows[0].StatusCode != 200 || rows[0].Bytes != 64 || !approx(rows[0].ResponseDelay, 0.008) || rows[0].Error != "" {
+			t.Errorf("unexpected first row: %+v", rows[0])
+		}
+		if rows[1].Error != "connection refused" || rows[1].StatusCode != 0 {
+			t.Errorf("unexpected error row: %+v", rows[1])
+		}
+		if rows[2].StatusCode != 500 || !approx(rows[2].Offset, 0.007) {
+			t.Errorf("unexpected last row: %+v", rows[2])
+		}
+		if summary {
+			var rep Report
+			if err := json.Unmarshal([]byte(lines[3]), &rep); err != nil {
+				t.Fatalf("summary line is not valid JSON: %v", err)
+			}
+			if rep.NumRes != 3 || len(rep.Lats) != 2 {
+				t.Errorf("summary has %d results and %d latencies; want 3 and 2", rep.NumRes, len(rep.Lats))
+			}
+		}
+	}
+}
+
+func TestHistogramBars(t *testing.T) {
+	buckets := []Bucket{
+		{Mark: 0.001, Count: 5},
+		{Mark: 0.002, Count: 20},
+		{Mark: 0.003, Count: 0},
+		{Mark: 0.004, Count: 10},
+	}
+	for _, width := range []int{0, 10, 80} {
+		want := width
+		if want == 0 {
+			want = defaultBarWidth
+		}
+		lines := strings.Split(strings.TrimSuffix(histogramFunc(width, Seconds)(buckets), "\n"), "\n")
+		if len(lines) != len(buckets) {
+			t.Fatalf("got %d lines; want %d", len(lines), len(buckets))
+		}
+		bars := make([]int, len(lines))
+		for i, line := range lines {
+			bars[i] = strings.Count(line, barChar)
+		}
+		if bars[1] != want {
+			t.Errorf("width %d: longest bar has %d characters; want %d", width, bars[1], want)
+		}
+		if bars[2] != 0 || !strings.HasSuffix(lines[2], "|") {
+			t.Errorf("width %d: empty bucket is drawn as %q", width, lines[2])
+		}
+		if bars[3] != (want+1)/2 {
+			t.Errorf("width %d: half bucket has %d characters; want %d", width, bars[3], (want+1)/2)
+		}
+	}
+}
+
+func TestReportString(t *testing.T) {
+	rep := Report{
+		NumRes:  1000,
+		NumErrs: 2,
+		Rps:     523.4567,
+		Average: 0.05,
+		Slowest: 0.0998,
+	}
+	for i := 998; i >= 1; i-- {
+		rep.Lats = append(rep.Lats, float64(i)/10000)
+	}
+	want := `Requests:     1000 (2 errors)
+Requests/sec: 523.4567
+Average:      0.0500
+p50/p95/p99:  0.0499 / 0.0949 / 0.0989
+Slowest:      0.0998
+`
+	if got := rep.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if rep.Lats[0] != 0.0998 {
+		t.Errorf("String sorted the latencies of the report")
+	}
+
+	// The percentiles of the latency distribution take precedence, e.g.
+	// if they are weighted or streamed.
+	rep.LatencyDistribution = []LatencyDistribution{
+		{Percentage: 50, Latency: 0.01}, {Percentage: 95, Latency: 0.02}, {Percentage: 99, Latency: 0.03},
+	}
+	if got := rep.String(); !strings.Contains(got, "p50/p95/p99:  0.0100 / 0.0200 / 0.0300\n") {
+		t.Errorf("got:\n%s\nwant the percentiles of the latency distribution", got)
+	}
+
+	want = `Requests:     0 (0 errors)
+Requests/sec: 0.0000
+Average:      0.0000
+p50/p95/p99:  0.0000 / 0.0000 / 0.0000
+Slowest:      0.0000
+`
+	if got := (Report{}).String(); got != want {
+		t.Errorf("empty report:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSummaryLine(t *testing.T) {
+	rep := Report{
+		NumRes:    1000,
+		Rps:       523.4567,
+		ErrorDist: map[string]int{"timeout": 1, "connection refused": 1},
+	}
+	for i := 1; i <= 998; i++ {
+		rep.Lats = append(rep.Lats, float64(i)/10000)
+	}
+	want := "SUMMARY requests=1000 errors=2 rps=523.5 p50=0.0499 p99=0.0989"
+	if got := summaryLine(rep); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+
+	// Streamed percentiles are taken from the latency distribution.
+	streamed := Report{NumRes: 10, Rps: 10, LatencyDistribution: []LatencyDistribution{
+		{Percentage: 50, Latency: 0.012}, {Percentage: 99, Latency: 0.034},
+	}}
+	want = "SUMMARY requests=10 errors=0 rps=10.0 p50=0.0120 p99=0.0340"
+	if got := summaryLine(streamed); got != want {
+		t.Errorf("streamed: got %q; want %q", got, want)
+	}
+
+	r := newTestReport(1)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.summaryLine = true
+	feed(r, &result{statusCode: 200, duration: ms(12)})
+	r.finalize(time.Second)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if got, want := lines[len(lines)-1], "SUMMARY requests=1 errors=0 rps=1.0 p50=0.0120 p99=0.0120"; got != want {
+		t.Errorf("last line = %q; want %q", got, want)
+	}
+}
+
+func TestPrintCompact(t *testing.T) {
+	r := newTestReport(5)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "compact"
+	r.percentiles = []float64{50, 90, 99, 99.9}
+	feed(r,
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(20)},
+		&result{statusCode: 404, duration: ms(30)},
+		&result{statusCode: 200, duration: ms(250)},
+		&result{err: errors.New("timeout")},
+	)
+	r.finalize(2 * time.Second)
+	checkGolden(t, "compact.golden", buf.Bytes())
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) >= 40 {
+			t.Errorf("line %q is %d columns wide; want less than 40", line, len(line))
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{formatMillis(0.0123), "12.30 ms"},
+		{formatBytes(0), "0 B"},
+		{formatBytes(1023), "1023 B"},
+		{formatBytes(1536), "1.5 KiB"},
+		{formatBytes(5 << 30), "5.0 GiB"},
+		{formatBytes(2147483648), "2.0 GiB"},
+		{formatPercent(0.125), "12.50%"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %q; want %q", tt.got, tt.want)
+		}
+	}
+
+	r := newTestReport(2)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = `{{ humanBytes .SizeTotal }} in {{ ms .Fastest }}, {{ pct .ErrorRate }} failed`
+	r.funcs = template.FuncMap{
+		"humanBytes": func(n int64) string { return fmt.Sprintf("%d bytes", n) },
+	}
+	feed(r,
+		&result{statusCode: 200, duration: ms(25), contentLength: 2048},
+		&result{err: errors.New("timeout")},
+	)
+	r.finalize(time.Second)
+	if got, want := buf.String(), "2048 bytes in 25.00 ms, 50.00% failed\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestLatencyUnit(t *testing.T) {
+	render := func(unit LatencyUnit) (string, Report) {
+		r := newTestReport(2)
+		buf := &bytes.Buffer{}
+		r.w = buf
+		r.histogramBuckets = 2
+		r.latencyUnit = unit
+		feed(r,
+			&result{statusCode: 200, duration: ms(10)},
+			&result{statusCode: 200, duration: ms(20)},
+		)
+		r.finalize(time.Second)
+		return buf.String(), r.snapshot()
+	}
+	secs, secsRep := render(Seconds)
+	millis, millisRep := render(Milliseconds)
+	for _, want := range []string{
+		"Average:\t15.0000 ms\n",
+		"Response time histogram (ms):\n  10.000 [1]",
+		"  50% in 10.0000 ms, ",
+		"  [200]\t15.0000 ms, 20.0000 ms",
+	} {
+		if !strings.Contains(millis, want) {
+			t.Errorf("summary in ms does not contain %q:\n%s", want, millis)
+		}
+	}
+	if !strings.Contains(secs, "Average:\t0.0150 secs\n") || !strings.Contains(secs, "Response time histogram:\n") {
+		t.Errorf("summary in seconds is not in seconds:\n%s", secs)
+	}
+	if secsRep.Average != millisRep.Average || !reflect.DeepEqual(secsRep.Lats, millisRep.Lats) {
+		t.Errorf("the unit changed the report: average %v and %v", secsRep.Average, millisRep.Average)
+	}
+	if got := Microseconds.format(0.0000123); got != "12.3000 µs" {
+		t.Errorf("Microseconds.format = %q; want 12.3000 µs", got)
+	}
+}
+
+func TestPrintMarkdown(t *testing.T) {
+	r := newTestReport(5)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "markdown"
+	r.percentiles = []float64{50, 90, 99}
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), contentLength: 1024},
+		&result{statusCode: 200, duration: ms(20), contentLength: 1024},
+		&result{statusCode: 404, duration: ms(30)},
+		&result{statusCode: 200, duration: ms(250), contentLength: 1024},
+		&result{err: errors.New("read: a | b")},
+	)
+	r.finalize(2 * time.Second)
+	checkGolden(t, "markdown.golden", buf.Bytes())
+}
+
+func TestPrintNoResponses(t *testing.T) {
+	for _, output := range []string{"", "compact"} {
+		r := newTestReport(3)
+		buf := &bytes.Buffer{}
+		r.w = buf
+		r.output = output
+		feed(r,
+			&result{err: errors.New("connection refused")},
+			&result{err: errors.New("connection refused")},
+			&result{err: errors.New("timeout")},
+		)
+		r.finalize(time.Second)
+		got := buf.String()
+		for _, want := range []string{
+			"0 successful responses; see error distribution below.",
+			"  [2]\tconnection refused\n",
+			"  [1]\ttimeout\n",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("output %q does not contain %q:\n%s", output, want, got)
+			}
+		}
+		if strings.Contains(got, "NaN") {
+			t.Errorf("output %q contains NaN:\n%s", output, got)
+		}
+		s := r.snapshot()
+		for name, v := range map[string]float64{
+			"Average": s.Average, "AvgConn": s.AvgConn, "AvgDNS": s.AvgDNS, "AvgTLS": s.AvgTLS,
+			"AvgReq": s.AvgReq, "AvgRes": s.AvgRes, "AvgDelay": s.AvgDelay, "AvgTTFB": s.AvgTTFB,
+		} {
+			if v != 0 {
+				t.Errorf("%s = %v; want 0", name, v)
+			}
+		}
+	}
+}
+
+func TestTargetRps(t *testing.T) {
+	tests := []struct {
+		n       int
+		pct     float64
+		warning bool
+	}{
+		{80, 80, true},
+		{95, 95, false},
+	}
+	for _, tt := range tests {
+		var results []*result
+		for i := 0; i < tt.n; i++ {
+			results = append(results, &result{statusCode: 200, duration: ms(10)})
+		}
+		r := newTestReport(tt.n)
+		buf := &bytes.Buffer{}
+		r.w = buf
+		r.targetRps = 100
+		feed(r, results...)
+		r.finalize(time.Second)
+		s := r.snapshot()
+		if s.TargetRps != 100 || !approx(s.RpsAchievedPct, tt.pct) {
+			t.Errorf("%d requests: TargetRps, RpsAchievedPct = %v, %v; want 100, %v", tt.n, s.TargetRps, s.RpsAchievedPct, tt.pct)
+		}
+		if got := strings.Contains(buf.String(), "WARNING:\tthe target rate was not reached"); got != tt.warning {
+			t.Errorf("%d requests: warning printed = %v; want %v:\n%s", tt.n, got, tt.warning, buf)
+		}
+	}
+
+	// Without a target, there is no percentage.
+	r := newTestReport(1)
+	feed(r, &result{statusCode: 200, duration: ms(10)})
+	r.finalize(time.Second)
+	if s := r.snapshot(); s.TargetRps != 0 || s.RpsAchievedPct != 0 {
+		t.Errorf("TargetRps, RpsAchievedPct = %v, %v without a target; want 0, 0", s.TargetRps, s.RpsAchievedPct)
+	}
+}
+
+func TestPhaseSegments(t *testing.T) {
+	rep := Report{
+		AvgDNS:   0.010,
+		AvgConn:  0.050, // 10ms DNS, 25ms TLS and 15ms dialup.
+		AvgTLS:   0.025,
+		AvgReq:   0.005,
+		AvgDelay: 0.040,
+		AvgRes:   0.005,
+	}
+	check := func(rep Report, wantWidths []int) {
+		var offset int
+		for i, s := range phaseSegments(rep, 40) {
+			if s.Offset != offset || s.Width != wantWidths[i] {
+				t.Errorf("segment %s at %d of width %d; want at %d of width %d", s.Name, s.Offset, s.Width, offset, wantWidths[i])
+			}
+			offset += s.Width
+		}
+		if offset != 40 {
+			t.Errorf("segments add up to %d; want the full bar of 40", offset)
+		}
+	}
+	// 2.5ms per character.
+	check(rep, []int{4, 6, 10, 2, 16, 2})
+	// The ends of the segments are rounded, at 3.3, 8.3, 16.7, 18.3 and
+	// 38.3 characters.
+	slow := rep
+	slow.AvgDelay = 0.060
+	check(slow, []int{3, 5, 9, 1, 20, 2})
+
+	for _, line := range strings.Split(strings.TrimSuffix(phaseStackFunc(40, Seconds)(rep), "\n"), "\n") {
+		bar := line[strings.Index(line, "|")+1 : strings.LastIndex(line, "|")]
+		if n := utf8.RuneCountInString(bar); n != 40 {
+			t.Errorf("line %q has a bar of %d characters; want 40", line, n)
+		}
+	}
+
+	if segments := phaseSegments(Report{}, 40); segments[0].Width != 0 {
+		t.Errorf("segments of an empty report = %+v; want empty", segments)
+	}
+}
+
+func TestPrintSizes(t *testing.T) {
+	r := newTestReport(2)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), contentLength: 1024},
+		&result{statusCode: 200, duration: ms(10), contentLength: 2048},
+	)
+	r.finalize(time.Second)
+	for _, want := range []string{"Total data:\t3.0 KiB\n", "Size/request:\t1.5 KiB\n", "Smallest:\t1.0 KiB\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary does not contain %q:\n%s", want, buf)
+		}
+	}
+	if s := r.snapshot(); s.SizeTotal != 3072 || s.SizeReq != 1536 {
+		t.Errorf("SizeTotal, SizeReq = %d, %d; want the raw 3072, 1536", s.SizeTotal, s.SizeReq)
+	}
+}
+
+func TestStructuredSummary(t *testing.T) {
+	results := []*result{
+		{statusCode: 200, duration: ms(10)},
+		{statusCode: 500, duration: ms(20)},
+		{err: errors.New("boom")},
+	}
+	want := map[string]float64{
+		"requests":   3,
+		"errors":     1,
+		"error_rate": 1.0 / 3,
+		"total":      2,
+		"rps":        1.5,
+		"goodput":    0.5,
+		"fastest":    0.01,
+		"slowest":    0.02,
+		"p50":        0.01,
+		"p99":        0.02,
+	}
+	run := func(format string) string {
+		r := newTestReport(len(results))
+		var out, diag bytes.Buffer
+		r.w, r.diagW = &out, &diag
+		r.structuredSummary = format
+		feed(r, results...)
+		if err := r.finalize(2 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "Summary:") {
+			t.Errorf("%s: the summary is not printed to the output", format)
+		}
+		if strings.Count(diag.String(), "\n") != 1 {
+			t.Fatalf("%s: diagnostics = %q; want a single line", format, diag.String())
+		}
+		return strings.TrimSuffix(diag.String(), "\n")
+	}
+	check := func(format string, got map[string]float64) {
+		for key, v := range want {
+			if !approx(got[key], v) {
+				t.Errorf("%s: %s = %v; want %v", format, key, got[key], v)
+			}
+		}
+	}
+
+	line := run("logfmt")
+	got := map[string]float64{}
+	pairs := strings.Fields(line)
+	if pairs[0] != "msg=summary" {
+		t.Errorf("logfmt line %q does not start with msg=summary", line)
+	}
+	for _, pair := range pairs[1:] {
+		key, value, ok := strings.Cut(pair, "=")
+		f, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil {
+			t.Fatalf("invalid pair %q in %q", pair, line)
+		}
+		got[key] = f
+	}
+	check("logfmt", got)
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(run("json")), &obj); err != nil {
+		t.Fatalf("json line is not valid JSON: %v", err)
+	}
+	got = map[string]float64{}
+	for key, v := range obj {
+		if f, ok := v.(float64); ok {
+			got[key] = f
+		}
+	}
+	check("json", got)
+	if obj["msg"] != "summary" {
+		t.Errorf("json msg = %v; want summary", obj["msg"])
+	}
+
+	// The keys come in the same order every time.
+	if a, b := run("logfmt"), run("logfmt"); a != b {
+		t.Errorf("logfmt lines differ:\n%s\n%s", a, b)
+	}
+	if err := writeStructuredSummary(io.Discard, "xml", Report{}); err == nil {
+		t.Errorf("unknown format: got no error")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// writePrometheus writes the report to w in the Prometheus text
+// exposition format.
+func writePrometheus(w io.Writer, rep Report) error {
+	bw := bufio.NewWriter(w)
+
+	var errors int64
+	for _, n := range rep.ErrorDist {
+		errors += int64(n)
+	}
+
+	writeMetricHeader(bw, "hey_requests_total", "counter", "Number of requests made.")
+	fmt.Fprintf(bw, "hey_requests_total %d\n", rep.NumRes)
+
+	writeMetricHeader(bw, "hey_request_duration_seconds", "summary", "Response times of the successful requests.")
+	for _, ld := range rep.LatencyDistribution {
+		fmt.Fprintf(bw, "hey_request_duration_seconds{quantile=\"%s\"} %s\n",
+			formatPromFloat(ld.Percentage/100), formatPromFloat(ld.Latency))
+	}
+	fmt.Fprintf(bw, "hey_request_duration_seconds_sum %s\n", formatPromFloat(rep.AvgTotal))
+	fmt.Fprintf(bw, "hey_request_duration_seconds_count %d\n", rep.NumRes-errors)
+
+	writeMetricHeader(bw, "hey_errors_total", "counter", "Number of failed requests, by error.")
+	errs := make([]string, 0, len(rep.ErrorDist))
+	for err := range rep.ErrorDist {
+		errs = append(errs, err)
+	}
+	sort.Strings(errs)
+	for _, err := range errs {
+		fmt.Fprintf(bw, "hey_errors_total{error=\"%s\"} %d\n", escapePromLabel(err), rep.ErrorDist[err])
+	}
+
+	writeMetricHeader(bw, "hey_status_codes_total", "counter", "Number of responses, by status code.")
+	codes := make([]int, 0, len(rep.StatusCodeDist))
+	for code := range rep.StatusCodeDist {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(bw, "hey_status_codes_total{code=\"%d\"} %d\n", code, rep.StatusCodeDist[code])
+	}
+	return bw.Flush()
+}
+
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func formatPromFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapePromLabel escapes a label value of the text exposition format.
+func escapePromLabel(v string) string {
+	return promLabelEscaper.Replace(v)
+}
@@ -0,0 +1,155 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	promCommentRe = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	promSampleRe  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{.*\})? (\S+)$`)
+)
+
+// parsePromText parses the text exposition format and returns the
+// samples keyed by their name and unescaped labels.
+func parsePromText(text string) (map[string]float64, error) {
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if strings.HasPrefix(line, "#") {
+			m := promCommentRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("invalid comment line %q", line)
+			}
+			if m[1] == "TYPE" {
+				switch m[3] {
+				case "counter", "gauge", "summary", "histogram", "untyped":
+				default:
+					return nil, fmt.Errorf("invalid type in %q", line)
+				}
+				types[m[2]] = m[3]
+			}
+			continue
+		}
+		m := promSampleRe.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("invalid sample line %q", line)
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(m[1], "_sum"), "_count")
+		if types[m[1]] == "" && types[base] == "" {
+			return nil, fmt.Errorf("sample %q has no TYPE", m[1])
+		}
+		v, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in %q: %v", line, err)
+		}
+		key := m[1]
+		if m[2] != "" {
+			labels, err := parsePromLabels(m[2][1 : len(m[2])-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid labels in %q: %v", line, err)
+			}
+			key += "{" + labels + "}"
+		}
+		samples[key] = v
+	}
+	return samples, nil
+}
+
+func parsePromLabels(s string) (string, error) {
+	var out []string
+	for s != "" {
+		eq := strings.Index(s, `="`)
+		if eq < 1 {
+			return "", errors.New("missing label value")
+		}
+		name := s[:eq]
+		var value strings.Builder
+		i := eq + 2
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] != '\\' {
+				value.WriteByte(s[i])
+				continue
+			}
+			i++
+			if i == len(s) {
+				return "", errors.New("unterminated escape")
+			}
+			switch s[i] {
+			case '\\', '"':
+				value.WriteByte(s[i])
+			case 'n':
+				value.WriteByte('\n')
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		}
+		if i == len(s) {
+			return "", errors.New("unterminated label value")
+		}
+		out = append(out, name+"="+value.String())
+		s = strings.TrimPrefix(s[i+1:], ",")
+	}
+	return strings.Join(out, ","), nil
+}
+
+func TestPrintPrometheus(t *testing.T) {
+	r := newTestReport(5)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "prometheus"
+	feed(r,
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(20)},
+		&result{statusCode: 503, duration: ms(30)},
+		&result{err: errors.New(`Get "http://x": dial tcp: connection refused`)},
+		&result{err: errors.New("line one\nline \\two")},
+	)
+	r.finalize(time.Second)
+
+	samples, err := parsePromText(buf.String())
+	if err != nil {
+		t.Fatalf("output does not parse: %v\n%s", err, buf)
+	}
+	want := map[string]float64{
+		"hey_requests_total":                                                   5,
+		"hey_request_duration_seconds{quantile=0.5}":                           0.02,
+		"hey_request_duration_seconds{quantile=0.99}":                          0.03,
+		"hey_request_duration_seconds_sum":                                     0.06,
+		"hey_request_duration_seconds_count":                                   3,
+		`hey_errors_total{error=Get "http://x": dial tcp: connection refused}`: 1,
+		"hey_errors_total{error=line one\nline \\two}":                         1,
+		"hey_status_codes_total{code=200}":                                     2,
+		"hey_status_codes_total{code=503}":                                     1,
+	}
+	for k, v := range want {
+		got, ok := samples[k]
+		if !ok {
+			t.Errorf("missing sample %q in:\n%s", k, buf)
+			continue
+		}
+		if !approx(got, v) {
+			t.Errorf("%s = %v; want %v", k, got, v)
+		}
+	}
+}
@@ -15,11 +15,29 @@
 package requester
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 )
 
@@ -27,139 +45,971 @@ const (
 	barChar = "■"
 )
 
-// We report for max 1M results.
+// We report for max 1M results, unless configured otherwise.
 const maxRes = 1000000
 
+// defaultHistogramBuckets is the number of histogram buckets
+// when none is configured.
+const defaultHistogramBuckets = 10
+
+// minLogMark is the lowest mark of a logarithmic histogram, in seconds.
+// It stands in for a fastest latency of zero.
+const minLogMark = 1e-6
+
+// defaultSlowestN is the number of slowest requests reported
+// when none is configured.
+const defaultSlowestN = 10
+
+// defaultCDFPoints is the number of points of the CDF
+// when none is configured.
+const defaultCDFPoints = 100
+
+// delayDominatedFraction is the fraction of the average latency beyond
+// which the response wait is reported to dominate it.
+const delayDominatedFraction = 0.3
+
+// defaultPercentiles are reported when no percentiles are configured.
+var defaultPercentiles = []float64{10, 25, 50, 75, 90, 95, 99}
+
+// defaultTailPercentiles are reported after the default percentiles if
+// there are enough samples for them to tell the tail apart from the
+// slowest sample, e.g. 1000 for p99.9.
+var defaultTailPercentiles = []float64{99.9, 99.99}
+
 type report struct {
+	// mu guards the report against snapshots while the run is in
+	// progress. The reporter holds it while it adds a result.
+	mu sync.Mutex
+
 	avgTotal float64
 	fastest  float64
 	slowest  float64
-	average  float64
 	rps      float64
 
+	// avgConn and the other averages are sums until they are divided by
+	// the number of latencies in the snapshots, like avgTotal.
 	avgConn     float64
 	avgDNS      float64
 	avgTLS      float64
 	avgReq      float64
 	avgRes      float64
 	avgDelay    float64
+	avgTTFB     float64
 	connLats    []float64
 	dnsLats     []float64
 	tlsLats     []float64
 	reqLats     []float64
 	resLats     []float64
 	delayLats   []float64
+	ttfbLats    []float64
 	offsets     []float64
 	statusCodes []int
+	// sizes are the content lengths of the samples, 0 if unknown.
+	sizes []int64
+	// weights are the weights of the samples, and weighted is set if
+	// any of them is not 1.
+	weights  []int
+	weighted bool
 
 	results chan *result
 	done    chan bool
 	total   time.Duration
 
-	errorDist map[string]int
+	errorDist         map[string]int
+	errorCategoryDist map[string]int
+	// errorPhaseDist is the number of errors by the phase of the request
+	// they occurred in.
+	errorPhaseDist map[string]int
+	// errorOffsets are the offsets of the failed requests, in seconds.
+	errorOffsets []float64
+	// firstErrorOffset and lastErrorOffset are the smallest and the
+	// largest offset of the failed requests, in seconds, unlike
+	// errorOffsets of all of them.
+	firstErrorOffset float64
+	lastErrorOffset  float64
+
+	// started and ended are the wall-clock times the report was created
+	// and finalized at, i.e. of the start and the end of the run.
+	started time.Time
+	ended   time.Time
+
 	lats      []float64
 	sizeTotal int64
+	sizeMin   int64
+	sizeMax   int64
 	numRes    int64
+	numErrs   int64
+	errorRate float64
+	numRows   int
 	output    string
 
+	// reqSizeTotal is the size of the bodies of the requests.
+	reqSizeTotal int64
+
+	connNew    int64
+	connReused int64
+	// connIDs are the connections the requests were made on.
+	connIDs map[string]struct{}
+	// numGood is the number of successful 2xx and 3xx responses.
+	numGood int64
+
+	// totalAttempts is the number of attempts of the requests, including
+	// retries, and retriedRequests the number of requests retried.
+	totalAttempts   int64
+	retriedRequests int64
+
+	percentiles   []float64
+	pctlMethod    PercentileMethod
+	streamSummary bool
+	summaryLine   bool
+	// structuredSummary is the format, "logfmt" or "json", of the line
+	// of the key metrics written to diagW once the run is done, if any.
+	structuredSummary string
+
+	// latencyUnit is the unit of the latencies in the summary.
+	latencyUnit LatencyUnit
+
+	// funcs are added to, and override, the functions of the template.
+	funcs template.FuncMap
+
+	histogramBuckets int
+	logHistogram     bool
+	barWidth         int
+	throughputWindow int
+	slowestN         int
+	cdfPoints        int
+
+	// trimPercent is the percentage of the lowest and of the highest
+	// latencies left out of the average, the deviation and the histogram.
+	trimPercent float64
+
+	// bootstrap is the number of bootstrap resamples the confidence band
+	// of the p95 latency is estimated from, none if zero. bootstrapSeed
+	// seeds the resampling of every snapshot, so that they agree.
+	bootstrap     int
+	bootstrapSeed int64
+
+	// phaseHistograms is set if the report has a histogram of each phase.
+	phaseHistograms bool
+
+	// skipHistogram and skipPhaseStats leave the histograms and the
+	// statistics of the phases out of the snapshots, which saves sorting
+	// and bucketing the samples of large runs.
+	skipHistogram  bool
+	skipPhaseStats bool
+
+	// latencySketch is set if the snapshots have the latency sketch,
+	// which the sketch output always has.
+	latencySketch bool
+
+	// bucketBounds are the fixed, ascending marks of the histogram
+	// buckets, in seconds. If empty, they span the samples.
+	bucketBounds []float64
+
+	// targetRps is the requested rate of the run, zero if unlimited.
+	targetRps float64
+
+	// apdexT is the Apdex threshold, in seconds. If zero, no Apdex
+	// score is computed.
+	apdexT float64
+
+	progressInterval int
+	// window holds the latencies of the last successful requests, whose
+	// p99 is printed with the progress, if it is non-nil.
+	window *latencyWindow
+
+	// diagW is where diagnostics, e.g. the progress and errors printing
+	// the report, are written, so that w only holds report data.
+	diagW io.Writer
+
+	// countTimeoutsAsLatency is set if timed out requests count in the
+	// latencies, with their duration capped at timeout, rather than
+	// being excluded like other errors.
+	countTimeoutsAsLatency bool
+	timeout                time.Duration
+
+	maxErrorRate float64
+	slo          []SLORule
+	sloResults   []SLOResult
+
+	// quiet is set if the report is only printed when the run fails the
+	// maximum error rate or the SLO rules.
+	quiet bool
+
+	// onResult is called with every result before it is added.
+	onResult func(Result)
+
+	// partial is set if the run was interrupted.
+	partial bool
+
+	// warmup is the duration at the start of the run, in seconds, whose
+	// requests are discarded.
+	warmup    float64
+	numWarmup int64
+
+	// maxSamples is the maximum number of retained samples.
+	maxSamples int
+	numSamples int64
+	reservoir  bool
+	rng        *rand.Rand
+	// numLats is the number of latencies the averages are over, whether
+	// or not they are retained as samples.
+	numLats int64
+
+	// streamed are the estimators of the percentiles of the latencies
+	// by percentile, if they are estimated as the results arrive rather
+	// than computed from retained samples, see streamPercentiles.
+	streamed map[float64]*p2Estimator
+	// streamedStatusCodes are the numbers of responses by status code
+	// if the percentiles are streamed.
+	streamedStatusCodes map[int]int
+
+	// reportURL is the URL the report is posted to as JSON, if any.
+	reportURL string
+
+	// syslogTag is the tag of the syslog messages of the report, which
+	// is only written to syslog if it is set, see writeSyslog.
+	syslogTag      string
+	syslogFacility string
+	syslogAddr     string
+
+	// influxMeasurement and influxTags are the measurement and the tags
+	// of the line of the "influx" output.
+	influxMeasurement string
+	influxTags        map[string]string
+
 	w io.Writer
+	// closer closes w once the report is printed, if it was opened for
+	// the report.
+	closer io.Closer
+	// appendTo is set if the report is appended to the file of w, in
+	// which case the JSON report is written on a single line.
+	appendTo bool
+
+	// runID identifies the run of the report.
+	runID string
+
+	// aggregated is set once the run is done and its rate is computed.
+	aggregated bool
+
+	// unknownStatus is set if the status code 0 of a sample means that
+	// it is not known, e.g. of ReportFromSamples, rather than that the
+	// request timed out. Such samples are left out of the statistics by
+	// status code.
+	unknownStatus bool
 }
 
-func newReport(w io.Writer, results chan *result, output string, n int) *report {
-	cap := min(n, maxRes)
+// newReport returns a report of the results of n requests, retaining
+// the samples of at most maxSamples of them. If maxSamples is zero, maxRes
+// samples are retained.
+func newReport(w io.Writer, results chan *result, output string, n, maxSamples int) *report {
+	if maxSamples <= 0 {
+		maxSamples = maxRes
+	}
+	cap := min(n, maxSamples)
+	if output == "csv" || output == "ndjson" {
+		// Results are streamed, samples are retained only if asked for.
+		cap = 0
+	}
 	return &report{
 		output:      output,
 		results:     results,
 		done:        make(chan bool, 1),
 		errorDist:   make(map[string]int),
 		w:           w,
+		diagW:       os.Stderr,
 		connLats:    make([]float64, 0, cap),
 		dnsLats:     make([]float64, 0, cap),
 		tlsLats:     make([]float64, 0, cap),
 		reqLats:     make([]float64, 0, cap),
 		resLats:     make([]float64, 0, cap),
 		delayLats:   make([]float64, 0, cap),
+		ttfbLats:    make([]float64, 0, cap),
 		lats:        make([]float64, 0, cap),
 		statusCodes: make([]int, 0, cap),
+		sizes:       make([]int64, 0, cap),
+		weights:     make([]int, 0, cap),
+
+		errorCategoryDist: make(map[string]int),
+		errorPhaseDist:    make(map[string]int),
+		connIDs:           make(map[string]struct{}),
+		maxSamples:        maxSamples,
+		rng:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		started:           time.Now(),
+		runID:             newRunID(),
+	}
+}
+
+// newFileReport is like newReport, but writes the report to the file at
+// path, creating its parent directories. If path ends in ".gz", the file
+// is compressed with gzip. The file is closed when the report is finalized.
+// If appendTo is set, the report is appended to the file rather than
+// replacing it, and the JSON report is written on a single line.
+func newFileReport(path string, appendTo bool, results chan *result, output string, n, maxSamples int) (*report, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	var w io.WriteCloser = f
+	if strings.HasSuffix(path, ".gz") {
+		w = &gzipFile{gzip.NewWriter(f), f}
+	}
+	r := newReport(w, results, output, n, maxSamples)
+	r.closer = w
+	r.appendTo = appendTo
+	return r, nil
+}
+
+// newRunID returns a random identifier of a run.
+func newRunID() string {
+	var id [8]byte
+	crand.Read(id[:])
+	return fmt.Sprintf("%x", id)
+}
+
+// gzipFile compresses the data written to a file.
+type gzipFile struct {
+	*gzip.Writer
+	f *os.File
+}
+
+// Close flushes the compressed data and closes the file.
+func (g *gzipFile) Close() error {
+	err := g.Writer.Close()
+	if cerr := g.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// addAttempts counts the attempts of a request. Zero stands for a
+// single attempt.
+func (r *report) addAttempts(attempts int) {
+	if attempts > 1 {
+		r.retriedRequests++
+	}
+	r.totalAttempts += int64(max(attempts, 1))
+}
+
+// addLatency adds the durations of res to the averages, and to the
+// samples if keep is set.
+func (r *report) addLatency(res *result, keep bool) {
+	r.numLats++
+	r.avgTotal += res.duration.Seconds()
+	r.avgConn += res.connDuration.Seconds()
+	r.avgDelay += res.delayDuration.Seconds()
+	r.avgDNS += res.dnsDuration.Seconds()
+	r.avgTLS += res.tlsDuration.Seconds()
+	r.avgReq += res.reqDuration.Seconds()
+	r.avgRes += res.resDuration.Seconds()
+	r.avgTTFB += res.ttfb().Seconds()
+	for _, e := range r.streamed {
+		e.add(res.duration.Seconds())
+	}
+	if r.streamed != nil {
+		r.streamedStatusCodes[res.statusCode]++
 	}
+	if keep {
+		r.addSample(res)
+	}
+}
+
+// add adds a result to the report, and writes its row of the streamed
+// output, if any. It reports whether the result counts, i.e. it was not
+// started during the warmup. The caller must hold r.mu.
+func (r *report) add(res *result, rows *bufio.Writer, keep bool) bool {
+	if res.offset.Seconds() < r.warmup {
+		r.numWarmup++
+		return false
+	}
+	r.numRes++
+	r.addAttempts(res.attempts)
+	if res.connID != "" {
+		r.connIDs[res.connID] = struct{}{}
+	}
+	if r.output == "ndjson" && rows != nil {
+		writeNDJSONRow(rows, res)
+	}
+	if res.err != nil {
+		r.numErrs++
+		r.errorDist[res.err.Error()]++
+		category := classifyError(res.err)
+		r.errorCategoryDist[category]++
+		if res.errPhase != "" {
+			r.errorPhaseDist[res.errPhase]++
+		}
+		offset := res.offset.Seconds()
+		if len(r.errorOffsets) < r.maxSamples {
+			r.errorOffsets = append(r.errorOffsets, offset)
+		}
+		if r.numErrs == 1 || offset < r.firstErrorOffset {
+			r.firstErrorOffset = offset
+		}
+		r.lastErrorOffset = max(r.lastErrorOffset, offset)
+		if r.countTimeoutsAsLatency && category == ErrCategoryTimeout {
+			timedOut := *res
+			if r.timeout > 0 {
+				timedOut.duration = min(timedOut.duration, r.timeout)
+			}
+			r.addLatency(&timedOut, keep)
+		}
+	} else {
+		r.addLatency(res, keep)
+		if res.statusCode < 400 {
+			r.numGood++
+		}
+		if r.output == "csv" && rows != nil && r.numRows < r.maxSamples {
+			writeCSVRow(rows, res)
+			r.numRows++
+		}
+		if res.connReused {
+			r.connReused++
+		} else {
+			r.connNew++
+		}
+		r.reqSizeTotal += res.reqSize
+		if res.contentLength > 0 {
+			r.sizeTotal += res.contentLength
+			if r.sizeMin == 0 || res.contentLength < r.sizeMin {
+				r.sizeMin = res.contentLength
+			}
+			if res.contentLength > r.sizeMax {
+				r.sizeMax = res.contentLength
+			}
+		}
+	}
+	return true
 }
 
 func runReporter(r *report) {
+	var rows *bufio.Writer
+	switch r.output {
+	case "csv":
+		rows = bufio.NewWriter(r.w)
+		rows.WriteString(csvHeader)
+	case "ndjson":
+		rows = bufio.NewWriter(r.w)
+	}
+	keep := r.keepsSamples()
+	start := now()
 	// Loop will continue until channel is closed
 	for res := range r.results {
-		r.numRes++
-		if res.err != nil {
-			r.errorDist[res.err.Error()]++
-		} else {
-			r.avgTotal += res.duration.Seconds()
-			r.avgConn += res.connDuration.Seconds()
-			r.avgDelay += res.delayDuration.Seconds()
-			r.avgDNS += res.dnsDuration.Seconds()
-			r.avgTLS += res.tlsDuration.Seconds()
-			r.avgReq += res.reqDuration.Seconds()
-			r.avgRes += res.resDuration.Seconds()
-			if len(r.resLats) < maxRes {
-				r.lats = append(r.lats, res.duration.Seconds())
-				r.connLats = append(r.connLats, res.connDuration.Seconds())
-				r.dnsLats = append(r.dnsLats, res.dnsDuration.Seconds())
-				r.tlsLats = append(r.tlsLats, res.tlsDuration.Seconds())
-				r.reqLats = append(r.reqLats, res.reqDuration.Seconds())
-				r.delayLats = append(r.delayLats, res.delayDuration.Seconds())
-				r.resLats = append(r.resLats, res.resDuration.Seconds())
-				r.statusCodes = append(r.statusCodes, res.statusCode)
-				r.offsets = append(r.offsets, res.offset.Seconds())
-			}
-			if res.contentLength > 0 {
-				r.sizeTotal += res.contentLength
+		if r.onResult != nil {
+			r.onResult(res.public())
+		}
+		r.mu.Lock()
+		counted := r.add(res, rows, keep)
+		r.mu.Unlock()
+		if !counted {
+			continue
+		}
+		if r.window != nil && res.err == nil {
+			r.window.add(res.duration.Seconds())
+		}
+		if r.progressInterval > 0 && r.numRes%int64(r.progressInterval) == 0 {
+			rps := float64(r.numRes) / (now() - start).Seconds()
+			r.diagf("\r%d requests done, %4.4f requests/sec, %d errors", r.numRes, rps, r.numErrs)
+			if r.window != nil {
+				r.diagf(", p99 of the last %d: %s", r.window.len(), r.latencyUnit.format(r.window.percentile(99, r.pctlMethod)))
 			}
 		}
+		// Flush whenever we caught up with the workers, so streamed
+		// output is visible while the run is in progress.
+		if rows != nil && len(r.results) == 0 {
+			rows.Flush()
+		}
+	}
+	if rows != nil {
+		rows.Flush()
+	}
+	if r.progressInterval > 0 && r.numRes >= int64(r.progressInterval) {
+		// Terminate the progress line before the report is printed.
+		r.diagf("\n")
 	}
 	// Signal reporter is done.
 	r.done <- true
 }
 
-func (r *report) finalize(total time.Duration) {
+var _ Reporter = (*report)(nil)
+
+// Record adds the result of a request to the report, like the results
+// of the run are. The rows of the csv and ndjson outputs are not written.
+func (r *report) Record(res Result) {
+	r.mu.Lock()
+	r.add(res.private(), nil, r.keepsSamples())
+	r.mu.Unlock()
+}
+
+// Finalize computes the averages of the report and prints it.
+func (r *report) Finalize(total time.Duration) error {
+	return r.finalize(total)
+}
+
+// latencyWindow is a ring buffer of the latest latencies.
+type latencyWindow struct {
+	lats []float64
+	next int
+	full bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{lats: make([]float64, size)}
+}
+
+// add adds a latency, replacing the oldest one once the window is full.
+func (w *latencyWindow) add(lat float64) {
+	w.lats[w.next] = lat
+	w.next++
+	if w.next == len(w.lats) {
+		w.next, w.full = 0, true
+	}
+}
+
+// len returns the number of latencies in the window.
+func (w *latencyWindow) len() int {
+	if w.full {
+		return len(w.lats)
+	}
+	return w.next
+}
+
+// percentile returns the p-th percentile of the latencies in the window.
+func (w *latencyWindow) percentile(p float64, method PercentileMethod) float64 {
+	return percentile(sortedCopy(w.lats[:w.len()]), p, method)
+}
+
+// addSample retains the samples of a successful result. Once maxSamples
+// results are retained, further results are dropped, unless reservoir
+// sampling is enabled, which keeps a uniform random sample of all the
+// results of the run.
+func (r *report) addSample(res *result) {
+	r.numSamples++
+	if len(r.lats) < r.maxSamples {
+		r.lats = append(r.lats, res.duration.Seconds())
+		r.connLats = append(r.connLats, res.connDuration.Seconds())
+		r.dnsLats = append(r.dnsLats, res.dnsDuration.Seconds())
+		r.tlsLats = append(r.tlsLats, res.tlsDuration.Seconds())
+		r.reqLats = append(r.reqLats, res.reqDuration.Seconds())
+		r.delayLats = append(r.delayLats, res.delayDuration.Seconds())
+		r.resLats = append(r.resLats, res.resDuration.Seconds())
+		r.ttfbLats = append(r.ttfbLats, res.ttfb().Seconds())
+		r.statusCodes = append(r.statusCodes, res.statusCode)
+		r.offsets = append(r.offsets, res.offset.Seconds())
+		r.sizes = append(r.sizes, max(res.contentLength, 0))
+		r.addWeight(res.weight)
+		return
+	}
+	if !r.reservoir {
+		return
+	}
+	// Replace a retained sample with probability maxSamples/numSamples.
+	i := r.rng.Int63n(r.numSamples)
+	if i >= int64(r.maxSamples) {
+		return
+	}
+	r.lats[i] = res.duration.Seconds()
+	r.connLats[i] = res.connDuration.Seconds()
+	r.dnsLats[i] = res.dnsDuration.Seconds()
+	r.tlsLats[i] = res.tlsDuration.Seconds()
+	r.reqLats[i] = res.reqDuration.Seconds()
+	r.delayLats[i] = res.delayDuration.Seconds()
+	r.resLats[i] = res.resDuration.Seconds()
+	r.ttfbLats[i] = res.ttfb().Seconds()
+	r.statusCodes[i] = res.statusCode
+	r.offsets[i] = res.offset.Seconds()
+	r.sizes[i] = max(res.contentLength, 0)
+	r.weights[i] = max(res.weight, 1)
+	r.weighted = r.weighted || res.weight > 1
+}
+
+func (r *report) addWeight(weight int) {
+	r.weights = append(r.weights, max(weight, 1))
+	r.weighted = r.weighted || weight > 1
+}
+
+// merge adds the results collected by other to r, see MergeReports. The
+// averages are derived from the merged sums in the snapshots, so other
+// may have been finalized. Samples beyond maxSamples are dropped.
+// Streamed percentile estimates cannot be merged, see streamPercentiles.
+func (r *report) merge(other *report) {
+	if other.numErrs > 0 {
+		if r.numErrs == 0 || other.firstErrorOffset < r.firstErrorOffset {
+			r.firstErrorOffset = other.firstErrorOffset
+		}
+		r.lastErrorOffset = max(r.lastErrorOffset, other.lastErrorOffset)
+	}
+	if !other.started.IsZero() && (r.started.IsZero() || other.started.Before(r.started)) {
+		r.started = other.started
+	}
+	if other.ended.After(r.ended) {
+		r.ended = other.ended
+	}
+	r.numRes += other.numRes
+	r.numErrs += other.numErrs
+	r.numGood += other.numGood
+	r.numWarmup += other.numWarmup
+	r.numSamples += other.numSamples
+	r.numLats += other.numLats
+	r.connNew += other.connNew
+	r.connReused += other.connReused
+	for id := range other.connIDs {
+		r.connIDs[id] = struct{}{}
+	}
+	r.totalAttempts += other.totalAttempts
+	r.retriedRequests += other.retriedRequests
+	r.avgTotal += other.avgTotal
+	r.avgConn += other.avgConn
+	r.avgDelay += other.avgDelay
+	r.avgDNS += other.avgDNS
+	r.avgTLS += other.avgTLS
+	r.avgReq += other.avgReq
+	r.avgRes += other.avgRes
+	r.avgTTFB += other.avgTTFB
+
+	r.sizeTotal += other.sizeTotal
+	r.reqSizeTotal += other.reqSizeTotal
+	if other.sizeMin > 0 && (r.sizeMin == 0 || other.sizeMin < r.sizeMin) {
+		r.sizeMin = other.sizeMin
+	}
+	r.sizeMax = max(r.sizeMax, other.sizeMax)
+
+	for msg, n := range other.errorDist {
+		r.errorDist[msg] += n
+	}
+	for category, n := range other.errorCategoryDist {
+		r.errorCategoryDist[category] += n
+	}
+	for phase, n := range other.errorPhaseDist {
+		r.errorPhaseDist[phase] += n
+	}
+	n := min(len(other.errorOffsets), r.maxSamples-len(r.errorOffsets))
+	r.errorOffsets = append(r.errorOffsets, other.errorOffsets[:max(n, 0)]...)
+
+	n = min(len(other.lats), r.maxSamples-len(r.lats))
+	if n <= 0 {
+		return
+	}
+	r.lats = append(r.lats, other.lats[:n]...)
+	r.connLats = append(r.connLats, other.connLats[:n]...)
+	r.dnsLats = append(r.dnsLats, other.dnsLats[:n]...)
+	r.tlsLats = append(r.tlsLats, other.tlsLats[:n]...)
+	r.reqLats = append(r.reqLats, other.reqLats[:n]...)
+	r.delayLats = append(r.delayLats, other.delayLats[:n]...)
+	r.resLats = append(r.resLats, other.resLats[:n]...)
+	r.ttfbLats = append(r.ttfbLats, other.ttfbLats[:n]...)
+	r.statusCodes = append(r.statusCodes, other.statusCodes[:n]...)
+	r.offsets = append(r.offsets, other.offsets[:n]...)
+	r.sizes = append(r.sizes, other.sizes[:n]...)
+	r.weights = append(r.weights, other.weights[:n]...)
+	r.weighted = r.weighted || other.weighted
+}
+
+// RawSamples are the samples of a run, e.g. recorded by another tool,
+// to build a report from with ReportFromSamples. Latencies are in
+// seconds, with an element per successful request in each slice. The
+// slices of the phases, StatusCodes and Offsets are optional.
+type RawSamples struct {
+	Lats        []float64
+	ConnLats    []float64
+	DnsLats     []float64
+	TlsLats     []float64
+	ReqLats     []float64
+	ResLats     []float64
+	DelayLats   []float64
+	StatusCodes []int
+	Offsets     []float64
+
+	// ErrorDist is the number of failed requests by error message.
+	ErrorDist map[string]int
+
+	// Total is the duration of the run.
+	Total time.Duration
+}
+
+// ReportFromSamples returns the report of a run from its samples, with
+// the same statistics as if its results were streamed to the reporter.
+// The error categories, phases and offsets are not known,
+// ErrorCategoryDist and ErrorPhaseDist are empty, FirstErrorOffset
+// and LastErrorOffset are -1, and StartTime and EndTime are zero. The
+// samples without a status code are left out of Goodput and of the
+// statistics by status code, e.g. StatusCodeDist and SlowestN.
+func ReportFromSamples(s RawSamples) Report {
+	n := len(s.Lats)
+	r := newReport(io.Discard, nil, "", n, n)
+	r.started = time.Time{}
+	r.unknownStatus = true
+	// Missing phases are zeros, so that all the samples stay aligned.
+	aligned := func(data []float64) []float64 {
+		res := make([]float64, n)
+		copy(res, data)
+		return res
+	}
+	r.lats = aligned(s.Lats)
+	r.connLats = aligned(s.ConnLats)
+	r.dnsLats = aligned(s.DnsLats)
+	r.tlsLats = aligned(s.TlsLats)
+	r.reqLats = aligned(s.ReqLats)
+	r.resLats = aligned(s.ResLats)
+	r.delayLats = aligned(s.DelayLats)
+	r.offsets = aligned(s.Offsets)
+	r.ttfbLats = make([]float64, n)
+	r.statusCodes = make([]int, n)
+	copy(r.statusCodes, s.StatusCodes)
+	r.sizes = make([]int64, n)
+	r.weights = make([]int, n)
+	for i := 0; i < n; i++ {
+		if r.statusCodes[i] > 0 && r.statusCodes[i] < 400 {
+			r.numGood++
+		}
+		r.ttfbLats[i] = r.lats[i] - r.resLats[i]
+		r.avgTotal += r.lats[i]
+		r.avgConn += r.connLats[i]
+		r.avgDNS += r.dnsLats[i]
+		r.avgTLS += r.tlsLats[i]
+		r.avgReq += r.reqLats[i]
+		r.avgRes += r.resLats[i]
+		r.avgDelay += r.delayLats[i]
+		r.avgTTFB += r.ttfbLats[i]
+	}
+	r.numSamples = int64(n)
+	r.numLats = int64(n)
+	for msg, num := range s.ErrorDist {
+		r.errorDist[msg] += num
+		r.numErrs += int64(num)
+	}
+	r.numRes = int64(n) + r.numErrs
+	r.aggregate(s.Total)
+	rep := r.snapshot()
+	rep.FirstErrorOffset, rep.LastErrorOffset = -1, -1
+	return rep
+}
+
+// mean returns the sum of n samples divided by n. Without samples, e.g.
+// if all the requests failed, it is zero rather than NaN.
+func mean(sum float64, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// average returns the average latency, weighted if the samples are.
+func (r *report) average() float64 {
+	if r.weighted {
+		return weightedMean(r.lats, r.weights)
+	}
+	return mean(r.avgTotal, r.numLatencies())
+}
+
+// numLatencies returns the number of latencies the averages are over,
+// including those of the samples that were dropped.
+func (r *report) numLatencies() int {
+	return int(r.numLats)
+}
+
+// keepsSamples reports whether the per-request samples are retained
+// for the final report.
+func (r *report) keepsSamples() bool {
+	if r.streamed != nil {
+		return false
+	}
+	switch r.output {
+	case "csv":
+		return false
+	case "ndjson":
+		return r.streamSummary
+	}
+	return true
+}
+
+// finalize computes the averages and prints the report. It returns an
+// error if the run failed the checks of the report, e.g. if the error
+// rate exceeds the configured maximum, joined with the errors of writing
+// the report to its sinks. In quiet mode, the report is only printed if
+// the run failed the checks.
+func (r *report) finalize(total time.Duration) (err error) {
+	if r.closer != nil {
+		// The output file is complete only once closed, e.g. if gzipped.
+		defer func() { err = errors.Join(err, r.closer.Close()) }()
+	}
+	r.mu.Lock()
+	r.ended = time.Now()
+	r.mu.Unlock()
+	r.aggregate(total)
+	checkErr := r.check()
+	// All the outputs share the snapshot, which sorts the samples.
+	rep := r.snapshot()
+	if !r.quiet || checkErr != nil {
+		r.print(rep)
+	}
+	// Every sink is written to, even if another one failed.
+	var summaryErr, postErr, syslogErr error
+	if r.structuredSummary != "" {
+		summaryErr = writeStructuredSummary(r.diagW, r.structuredSummary, rep)
+	}
+	if r.reportURL != "" {
+		postErr = postReport(r.reportURL, rep)
+	}
+	if r.syslogTag != "" {
+		syslogErr = writeSyslog(r.syslogAddr, r.syslogFacility, r.syslogTag, rep)
+	}
+	return errors.Join(checkErr, summaryErr, postErr, syslogErr)
+}
+
+// check returns an error if the run failed the checks of the report:
+// the maximum error rate and the SLO rules.
+func (r *report) check() error {
+	if r.maxErrorRate > 0 && r.errorRate > r.maxErrorRate {
+		return fmt.Errorf("error rate %.2f%% exceeds the maximum of %.2f%%", r.errorRate*100, r.maxErrorRate*100)
+	}
+	var failed []string
+	for _, res := range r.sloResults {
+		switch {
+		case res.NoLatencies:
+			failed = append(failed, fmt.Sprintf("%v (no latencies)", res.Rule))
+		case !res.Passed:
+			failed = append(failed, fmt.Sprintf("%v (actual %.4f)", res.Rule, res.Actual))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("SLO failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// aggregate computes the rates of a run of the given duration, once all
+// its results are added, and evaluates the SLO.
+func (r *report) aggregate(total time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.total = total
-	r.rps = float64(r.numRes) / r.total.Seconds()
-	r.average = r.avgTotal / float64(len(r.lats))
-	r.avgConn = r.avgConn / float64(len(r.connLats))
-	r.avgDelay = r.avgDelay / float64(len(r.delayLats))
-	r.avgDNS = r.avgDNS / float64(len(r.dnsLats))
-	r.avgTLS = r.avgTLS / float64(len(r.tlsLats))
-	r.avgReq = r.avgReq / float64(len(r.reqLats))
-	r.avgRes = r.avgRes / float64(len(r.resLats))
-	r.print()
-}
-
-func (r *report) print() {
+	if d := r.total.Seconds() - r.warmup; d > 0 {
+		r.rps = float64(r.numRes) / d
+	}
+	if r.numRes > 0 {
+		r.errorRate = float64(r.numErrs) / float64(r.numRes)
+	}
+	r.aggregated = true
+	r.sloResults = r.evaluateSLO()
+}
+
+// print writes rep, the snapshot of r, in the output format of r.
+func (r *report) print(rep Report) {
+	switch r.output {
+	case "csv":
+		// Rows have been written by the reporter.
+		return
+	case "json":
+		write := writeJSON
+		if r.appendTo {
+			// One report per line, so that the file is NDJSON.
+			write = func(w io.Writer, v interface{}) error { return json.NewEncoder(w).Encode(v) }
+		}
+		if err := write(r.w, finiteReport(rep)); err != nil {
+			r.diagf("error: %v\n", err)
+		}
+		return
+	case "yaml":
+		if err := writeYAML(r.w, rep); err != nil {
+			r.diagf("error: %v\n", err)
+		}
+		return
+	case "prometheus":
+		if err := writePrometheus(r.w, rep); err != nil {
+			r.diagf("error: %v\n", err)
+		}
+		return
+	case "influx":
+		if err := writeInflux(r.w, r.influxMeasurement, r.influxTags, rep); err != nil {
+			r.diagf("error: %v\n", err)
+		}
+		return
+	case "html":
+		if err := writeHTML(r.w, rep); err != nil {
+			r.diagf("error: %v\n", err)
+		}
+		return
+	case "sketch":
+		if _, err := r.w.Write(rep.LatencySketch); err != nil {
+			r.diagf("error: %v\n", err)
+		}
+		return
+	case "ndjson":
+		if !r.streamSummary {
+			return
+		}
+		if err := json.NewEncoder(r.w).Encode(finiteReport(rep)); err != nil {
+			r.diagf("error: %v\n", err)
+		}
+		return
+	}
+
+	funcs := template.FuncMap{
+		"histogram":  histogramFunc(r.barWidth, r.latencyUnit),
+		"phaseStack": phaseStackFunc(r.barWidth, r.latencyUnit),
+		"latency":    r.latencyUnit.format,
+		"unit":       r.latencyUnit.suffix,
+	}
+	for name, fn := range r.funcs {
+		funcs[name] = fn
+	}
+	output := r.output
+	if len(rep.Lats) == 0 && len(rep.LatencyDistribution) == 0 && (output == "" || output == "compact") {
+		// The statistics of the responses would all be zeros.
+		output = noResponsesTmpl
+	}
 	buf := &bytes.Buffer{}
-	if err := newTemplate(r.output).Execute(buf, r.snapshot()); err != nil {
-		log.Println("error:", err.Error())
+	if err := newTemplate(output, funcs).Execute(buf, rep); err != nil {
+		r.diagf("error: %v\n", err)
 		return
 	}
-	r.printf(buf.String())
+	r.w.Write(buf.Bytes())
 
 	r.printf("\n")
+	if r.summaryLine {
+		fmt.Fprintln(r.w, summaryLine(rep))
+	}
 }
 
 func (r *report) printf(s string, v ...interface{}) {
 	fmt.Fprintf(r.w, s, v...)
 }
 
+func (r *report) diagf(s string, v ...interface{}) {
+	fmt.Fprintf(r.diagW, s, v...)
+}
+
+// snapshot returns the statistics of the report. It works on copies of
+// the samples, so that it can be called while the run is in progress.
 func (r *report) snapshot() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := r.numLatencies()
 	snapshot := Report{
 		AvgTotal:    r.avgTotal,
-		Average:     r.average,
+		Average:     r.average(),
 		Rps:         r.rps,
 		SizeTotal:   r.sizeTotal,
-		AvgConn:     r.avgConn,
-		AvgDNS:      r.avgDNS,
-		AvgTLS:      r.avgTLS,
-		AvgReq:      r.avgReq,
-		AvgRes:      r.avgRes,
-		AvgDelay:    r.avgDelay,
+		SizeMin:     r.sizeMin,
+		SizeMax:     r.sizeMax,
+		AvgConn:     mean(r.avgConn, n),
+		AvgDNS:      mean(r.avgDNS, n),
+		AvgTLS:      mean(r.avgTLS, n),
+		AvgReq:      mean(r.avgReq, n),
+		AvgRes:      mean(r.avgRes, n),
+		AvgDelay:    mean(r.avgDelay, n),
+		AvgTTFB:     mean(r.avgTTFB, n),
 		Total:       r.total,
-		ErrorDist:   r.errorDist,
+		StartTime:   r.started,
+		EndTime:     r.ended,
+		ErrorDist:   maps.Clone(r.errorDist),
 		NumRes:      r.numRes,
 		Lats:        make([]float64, len(r.lats)),
 		ConnLats:    make([]float64, len(r.lats)),
@@ -168,15 +1018,91 @@ func (r *report) snapshot() Report {
 		ReqLats:     make([]float64, len(r.lats)),
 		ResLats:     make([]float64, len(r.lats)),
 		DelayLats:   make([]float64, len(r.lats)),
+		TtfbLats:    make([]float64, len(r.lats)),
 		Offsets:     make([]float64, len(r.lats)),
 		StatusCodes: make([]int, len(r.lats)),
+
+		ErrorRate:         r.errorRate,
+		ErrorCategoryDist: maps.Clone(r.errorCategoryDist),
+		ErrorPhaseDist:    maps.Clone(r.errorPhaseDist),
+		NumWarmup:         r.numWarmup,
+		Partial:           r.partial,
+		OutputMode:        r.output,
+		RunID:             r.runID,
+		SLOResults:        r.sloResults,
+		ReqSizeTotal:      r.reqSizeTotal,
+		NumErrs:           r.numErrs,
+		ConnNew:           r.connNew,
+		ConnReused:        r.connReused,
+		TotalAttempts:     r.totalAttempts,
+		RetriedRequests:   r.retriedRequests,
+		SampledCount:      int64(len(r.lats)),
+		DroppedCount:      r.numSamples - int64(len(r.lats)),
+	}
+
+	// The rates are over the duration of the run or, while it is in
+	// progress, over the time elapsed so far.
+	elapsed := r.total.Seconds()
+	if !r.aggregated && !r.started.IsZero() {
+		elapsed = time.Since(r.started).Seconds()
+		if d := elapsed - r.warmup; d > 0 {
+			snapshot.Rps = float64(r.numRes) / d
+		}
+	}
+
+	if r.latencySketch || r.output == "sketch" {
+		snapshot.LatencySketch = r.sketch().encode()
+	}
+
+	if r.targetRps > 0 {
+		snapshot.TargetRps = r.targetRps
+		snapshot.RpsAchievedPct = snapshot.Rps / r.targetRps * 100
+	}
+
+	if d := elapsed - r.warmup; d > 0 {
+		snapshot.MBPerSec = float64(r.sizeTotal+r.reqSizeTotal) / 1e6 / d
+		snapshot.Goodput = float64(r.numGood) / d
+	}
+
+	snapshot.DistinctConnections = len(r.connIDs)
+	snapshot.ErrorsPerSecond = errorsPerSecond(r.errorOffsets)
+	snapshot.FirstErrorOffset, snapshot.LastErrorOffset = -1, -1
+	if r.numErrs > 0 {
+		snapshot.FirstErrorOffset = r.firstErrorOffset
+		snapshot.LastErrorOffset = r.lastErrorOffset
+	}
+
+	if r.numLatencies() > 0 && r.streamed != nil {
+		snapshot.LatencyDistribution = r.streamedLatencies()
+		snapshot.Median = r.streamed[50].value()
+		snapshot.Fastest = r.streamed[50].min()
+		snapshot.Slowest = r.streamed[50].max()
+		snapshot.NormalizedDistribution = normalizedDistribution(snapshot.LatencyDistribution, snapshot.Median)
+		snapshot.StatusCodeDist = maps.Clone(r.streamedStatusCodes)
+		snapshot.StatusCodeCounts = statusCodeCounts(snapshot.StatusCodeDist)
+	}
+
+	// The statistics of the totals do not need the samples, which are not
+	// retained if the percentiles are streamed.
+	if r.numLats > 0 {
+		snapshot.SizeReq = r.sizeTotal / r.numLats
+	}
+	if snapshot.Average > 0 {
+		snapshot.DelayFraction = snapshot.AvgDelay / snapshot.Average
+		snapshot.DelayDominated = snapshot.DelayFraction > delayDominatedFraction
 	}
 
 	if len(r.lats) == 0 {
 		return snapshot
 	}
 
-	snapshot.SizeReq = r.sizeTotal / int64(len(r.lats))
+	snapshot.Stddev = stddev(r.lats)
+	if r.weighted {
+		snapshot.Stddev = weightedStddev(r.lats, r.weights)
+	}
+	snapshot.GeoMean = geoMean(r.lats)
+	snapshot.ArrivalCV = arrivalCV(r.offsets)
+	snapshot.PeakConcurrency, snapshot.AvgConcurrency = concurrency(r.offsets, r.lats)
 
 	copy(snapshot.Lats, r.lats)
 	copy(snapshot.ConnLats, r.connLats)
@@ -185,112 +1111,956 @@ func (r *report) snapshot() Report {
 	copy(snapshot.ReqLats, r.reqLats)
 	copy(snapshot.ResLats, r.resLats)
 	copy(snapshot.DelayLats, r.delayLats)
+	copy(snapshot.TtfbLats, r.ttfbLats)
 	copy(snapshot.StatusCodes, r.statusCodes)
 	copy(snapshot.Offsets, r.offsets)
 
-	sort.Float64s(r.lats)
-	r.fastest = r.lats[0]
-	r.slowest = r.lats[len(r.lats)-1]
+	snapshot.StatusLatencies = r.statusLatencies()
+	snapshot.SlowestN = r.slowestRequests()
+	if r.apdexT > 0 {
+		snapshot.ApdexT = r.apdexT
+		snapshot.Apdex = apdex(r.lats, r.apdexT)
+	}
 
-	sort.Float64s(r.connLats)
-	sort.Float64s(r.dnsLats)
-	sort.Float64s(r.tlsLats)
-	sort.Float64s(r.reqLats)
-	sort.Float64s(r.resLats)
-	sort.Float64s(r.delayLats)
+	// Sort copies of the samples, so that they stay aligned with
+	// r.statusCodes and r.offsets in arrival order.
+	sorted := r.sortedSamples()
+	r.fastest = sorted.lats[0]
+	r.slowest = sorted.lats[len(sorted.lats)-1]
+	snapshot.SortedLats = sorted.lats
+	// The median interpolates between the two middle samples of an even count.
+	snapshot.Median = percentile(sorted.lats, 50, LinearInterpolation)
+	if r.weighted {
+		snapshot.Median = weightedPercentile(sorted.weighted, 50)
+	}
 
-	// TODO: consider other histograms?
-	snapshot.Histogram = r.histogram(r.lats)
-	snapshot.LatencyDistribution = r.latencies()
+	// A sample of weight w counts as w samples of the confidence interval.
+	ciSamples := len(r.lats)
+	if r.weighted {
+		ciSamples = sumWeights(r.weights)
+	}
+	if r.trimPercent > 0 {
+		// Outliers are left out of the average, the deviation and the
+		// histogram, but not out of the fastest and the slowest.
+		trimmed := trim(sorted.lats, r.trimPercent)
+		ciSamples = len(trimmed)
+		var sum float64
+		for _, v := range trimmed {
+			sum += v
+		}
+		snapshot.Average = mean(sum, len(trimmed))
+		snapshot.Stddev = stddev(trimmed)
+		if r.weighted {
+			w := trimWeighted(sorted.weighted, r.trimPercent)
+			ciSamples = sumWeights(w.weights)
+			snapshot.Average = weightedMean(w.values, w.weights)
+			snapshot.Stddev = weightedStddev(w.values, w.weights)
+		}
+		if !r.skipHistogram {
+			snapshot.Histogram = r.histogram(trimmed)
+		}
+	} else if !r.skipHistogram {
+		snapshot.Histogram = r.histogram(sorted.lats)
+	}
+	if ciSamples >= minCISamples {
+		snapshot.MeanCILow, snapshot.MeanCIHigh = meanCI(snapshot.Average, snapshot.Stddev, ciSamples)
+	}
+	if r.bootstrap > 0 {
+		rng := rand.New(rand.NewSource(r.bootstrapSeed))
+		snapshot.P95CILow, snapshot.P95CIHigh = bootstrapPercentile(sorted.lats, 95, r.bootstrap, rng, r.pctlMethod)
+	}
+	if r.phaseHistograms && !r.skipHistogram && !r.skipPhaseStats {
+		snapshot.PhaseHistograms = r.phaseHistogramsOf(sorted)
+	}
+	snapshot.ModeBucketIndex, snapshot.ModeBucketRange = modeBucket(snapshot.Histogram)
+	snapshot.ModalRanges = bimodalRanges(snapshot.Histogram)
+	snapshot.Bimodal = snapshot.ModalRanges != nil
+	snapshot.LatencyDistribution = r.latencies(sorted)
+	p50 := r.latencyPercentile(sorted, 50)
+	if p50 > 0 {
+		snapshot.P99P50Ratio = r.latencyPercentile(sorted, 99) / p50
+	}
+	snapshot.NormalizedDistribution = normalizedDistribution(snapshot.LatencyDistribution, p50)
+	snapshot.IQR = r.latencyPercentile(sorted, 75) - r.latencyPercentile(sorted, 25)
+	snapshot.MAD = mad(sorted.lats)
+	snapshot.IQMean = iqMean(sorted.lats)
+	snapshot.CDF = r.cdf(sorted.lats)
 
 	snapshot.Fastest = r.fastest
 	snapshot.Slowest = r.slowest
-	snapshot.ConnMax = r.connLats[0]
-	snapshot.ConnMin = r.connLats[len(r.connLats)-1]
-	snapshot.DnsMax = r.dnsLats[0]
-	snapshot.DnsMin = r.dnsLats[len(r.dnsLats)-1]
-	if len(r.tlsLats) > 0 {
-		snapshot.TlsMax = r.tlsLats[0]
-		snapshot.TlsMin = r.tlsLats[len(r.tlsLats)-1]
-	}
-	snapshot.ReqMax = r.reqLats[0]
-	snapshot.ReqMin = r.reqLats[len(r.reqLats)-1]
-	snapshot.DelayMax = r.delayLats[0]
-	snapshot.DelayMin = r.delayLats[len(r.delayLats)-1]
-	snapshot.ResMax = r.resLats[0]
-	snapshot.ResMin = r.resLats[len(r.resLats)-1]
+	if !r.skipPhaseStats {
+		snapshot.ConnMax = sorted.connLats[len(sorted.connLats)-1]
+		snapshot.ConnMin = sorted.connLats[0]
+		snapshot.DnsMax = sorted.dnsLats[len(sorted.dnsLats)-1]
+		snapshot.DnsMin = sorted.dnsLats[0]
+		if len(sorted.tlsLats) > 0 {
+			snapshot.TlsMax = sorted.tlsLats[len(sorted.tlsLats)-1]
+			snapshot.TlsMin = sorted.tlsLats[0]
+		}
+		snapshot.ReqMax = sorted.reqLats[len(sorted.reqLats)-1]
+		snapshot.ReqMin = sorted.reqLats[0]
+		snapshot.DelayMax = sorted.delayLats[len(sorted.delayLats)-1]
+		snapshot.DelayMin = sorted.delayLats[0]
+		snapshot.ResMax = sorted.resLats[len(sorted.resLats)-1]
+		snapshot.ResMin = sorted.resLats[0]
+		snapshot.TtfbMax = sorted.ttfbLats[len(sorted.ttfbLats)-1]
+		snapshot.TtfbMin = sorted.ttfbLats[0]
+	}
 
 	statusCodeDist := make(map[int]int, len(snapshot.StatusCodes))
 	for _, statusCode := range snapshot.StatusCodes {
+		if statusCode == 0 && r.unknownStatus {
+			continue
+		}
 		statusCodeDist[statusCode]++
 	}
 	snapshot.StatusCodeDist = statusCodeDist
+	snapshot.StatusCodeCounts = statusCodeCounts(statusCodeDist)
+	snapshot.Throughput = r.throughput()
 
 	return snapshot
 }
 
-func (r *report) latencies() []LatencyDistribution {
-	pctls := []int{10, 25, 50, 75, 90, 95, 99}
-	data := make([]float64, len(pctls))
-	j := 0
+// sketch returns a latency sketch of the retained samples.
+func (r *report) sketch() *ddSketch {
+	s := newDDSketch(sketchAccuracy)
+	for _, v := range r.lats {
+		s.add(v)
+	}
+	return s
+}
 
-	// TODO: loop over percentiles
-	// For each percentile, create the latency distribution directly
-	for i := 0; i < len(r.lats) && j < len(pctls); i++ {
-		current := i * 100 / len(r.lats)
-		if current >= pctls[j] {
-			data[j] = r.lats[i]
-			j++
+// slowestRequests returns the slowest samples, slowest first, along with
+// their offset and status code.
+func (r *report) slowestRequests() []SlowRequest {
+	n := r.slowestN
+	if n < 1 {
+		n = defaultSlowestN
+	}
+	res := make([]SlowRequest, 0, min(n, len(r.lats)))
+	for i, v := range r.lats {
+		if r.statusCodes[i] == 0 && r.unknownStatus {
+			continue
+		}
+		if len(res) == n && v <= res[n-1].Latency {
+			continue
+		}
+		// Insert in order, dropping the fastest if there are n already.
+		j := sort.Search(len(res), func(j int) bool { return res[j].Latency < v })
+		if len(res) < n {
+			res = append(res, SlowRequest{})
+		}
+		copy(res[j+1:], res[j:])
+		res[j] = SlowRequest{Latency: v, Offset: r.offsets[i], StatusCode: r.statusCodes[i]}
+	}
+	return res
+}
+
+// apdex returns the Apdex score of the latencies for the threshold t:
+// the fraction of satisfied requests, at most t, plus half the fraction
+// of tolerated requests, at most 4t.
+func apdex(lats []float64, t float64) float64 {
+	var satisfied, tolerating int
+	for _, v := range lats {
+		switch {
+		case v <= t:
+			satisfied++
+		case v <= 4*t:
+			tolerating++
+		}
+	}
+	return (float64(satisfied) + float64(tolerating)/2) / float64(len(lats))
+}
+
+// statusCodeCounts returns the status code distribution sorted by code.
+func statusCodeCounts(dist map[int]int) []StatusCodeCount {
+	res := make([]StatusCodeCount, 0, len(dist))
+	for code, n := range dist {
+		class := "error"
+		if code > 0 {
+			class = fmt.Sprintf("%dxx", code/100)
 		}
+		res = append(res, StatusCodeCount{Code: code, Count: n, Class: class})
 	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Code < res[j].Code })
+	return res
+}
+
+// samples holds the latency samples of each phase.
+type samples struct {
+	lats      []float64
+	connLats  []float64
+	dnsLats   []float64
+	tlsLats   []float64
+	reqLats   []float64
+	resLats   []float64
+	delayLats []float64
+	ttfbLats  []float64
+	// weighted are the latencies along with their weights, if the
+	// samples have any.
+	weighted weightedSamples
+}
+
+// sortedSamples returns sorted copies of the latency samples. If the
+// statistics of the phases are skipped, only the latencies are sorted.
+func (r *report) sortedSamples() *samples {
+	s := &samples{lats: sortedCopy(r.lats)}
+	if r.weighted {
+		s.weighted = sortWeighted(r.lats, r.weights)
+	}
+	if r.skipPhaseStats {
+		return s
+	}
+	s.connLats = sortedCopy(r.connLats)
+	s.dnsLats = sortedCopy(r.dnsLats)
+	s.tlsLats = sortedCopy(r.tlsLats)
+	s.reqLats = sortedCopy(r.reqLats)
+	s.resLats = sortedCopy(r.resLats)
+	s.delayLats = sortedCopy(r.delayLats)
+	s.ttfbLats = sortedCopy(r.ttfbLats)
+	return s
+}
+
+func sortedCopy(data []float64) []float64 {
+	c := make([]float64, len(data))
+	copy(c, data)
+	sort.Float64s(c)
+	return c
+}
+
+// latencies computes the latency distribution of the sorted samples.
+// The per-phase latencies are percentiles of each phase on its own, so
+// they are not required to add up to the total latency of the same
+// percentile.
+func (r *report) latencies(sorted *samples) []LatencyDistribution {
+	if len(sorted.lats) == 0 {
+		return nil
+	}
+	pctls := r.reportedPercentiles(len(sorted.lats))
+	res := make([]LatencyDistribution, len(pctls))
+	for i, p := range pctls {
+		res[i] = LatencyDistribution{
+			Percentage:   p,
+			Latency:      r.latencyPercentile(sorted, p),
+			DnsLatency:   percentile(sorted.dnsLats, p, r.pctlMethod),
+			ConnLatency:  percentile(sorted.connLats, p, r.pctlMethod),
+			TlsLatency:   percentile(sorted.tlsLats, p, r.pctlMethod),
+			DelayLatency: percentile(sorted.delayLats, p, r.pctlMethod),
+			ReqLatency:   percentile(sorted.reqLats, p, r.pctlMethod),
+			RespLatency:  percentile(sorted.resLats, p, r.pctlMethod),
+			TtfbLatency:  percentile(sorted.ttfbLats, p, r.pctlMethod),
+		}
+	}
+	return res
+}
+
+// streamPercentiles makes the report estimate the percentiles of the
+// latencies as the results arrive, instead of retaining their samples.
+// The memory it uses is constant, but the percentiles are estimates, see
+// p2Estimator, and the statistics that need the samples, such as the
+// histogram and the per-phase percentiles, are left out, except for the
+// status code distribution, which is counted instead. It must be
+// called after the percentiles and the SLO rules are set.
+func (r *report) streamPercentiles() {
+	pctls := r.percentiles
+	if len(pctls) == 0 {
+		pctls = append(defaultPercentiles[:len(defaultPercentiles):len(defaultPercentiles)], defaultTailPercentiles...)
+	}
+	r.streamed = map[float64]*p2Estimator{50: newP2Estimator(50)}
+	r.streamedStatusCodes = make(map[int]int)
+	for _, p := range pctls {
+		r.streamed[p] = newP2Estimator(p)
+	}
+	for _, rule := range r.slo {
+		if p, ok := sloPercentile(rule.Metric); ok {
+			r.streamed[p] = newP2Estimator(p)
+		}
+	}
+}
+
+// streamedLatencies returns the latency distribution of the streamed
+// estimates. Only the latencies of the requests are estimated.
+func (r *report) streamedLatencies() []LatencyDistribution {
+	pctls := r.reportedPercentiles(r.numLatencies())
 	res := make([]LatencyDistribution, len(pctls))
-	for i := 0; i < len(pctls); i++ {
-		if data[i] > 0 {
-			res[i] = LatencyDistribution{Percentage: pctls[i], Latency: data[i]}
+	for i, p := range pctls {
+		res[i] = LatencyDistribution{Percentage: p, Latency: r.streamed[p].value()}
+	}
+	return res
+}
+
+// reportedPercentiles returns the percentiles of the latency
+// distribution of n samples: the configured ones, or else the default
+// ones along with the tail ones that n samples are enough for.
+func (r *report) reportedPercentiles(n int) []float64 {
+	if len(r.percentiles) > 0 {
+		return r.percentiles
+	}
+	pctls := defaultPercentiles
+	for _, p := range defaultTailPercentiles {
+		// At least one sample is above the p-th percentile.
+		if float64(n)*(100-p) >= 100-1e-9 {
+			pctls = append(pctls[:len(pctls):len(pctls)], p)
+		}
+	}
+	return pctls
+}
+
+// latencyPercentile returns the p-th percentile of the sorted
+// latencies, weighted by the weights of the samples if they have any.
+func (r *report) latencyPercentile(sorted *samples, p float64) float64 {
+	if r.weighted {
+		return weightedPercentile(sorted.weighted, p)
+	}
+	return percentile(sorted.lats, p, r.pctlMethod)
+}
+
+// weightedMean returns the mean of data, each value counting as many
+// times as its weight.
+func weightedMean(data []float64, weights []int) float64 {
+	var sum, total float64
+	for i, v := range data {
+		sum += v * float64(weights[i])
+		total += float64(weights[i])
+	}
+	return sum / total
+}
+
+// weightedSamples are samples sorted by value, along with their weights.
+type weightedSamples struct {
+	values  []float64
+	weights []int
+}
+
+// sortWeighted returns the samples of data sorted by value, with their
+// weights.
+func sortWeighted(data []float64, weights []int) weightedSamples {
+	idx := make([]int, len(data))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return data[idx[a]] < data[idx[b]] })
+	res := weightedSamples{values: make([]float64, len(data)), weights: make([]int, len(data))}
+	for j, i := range idx {
+		res.values[j] = data[i]
+		res.weights[j] = weights[i]
+	}
+	return res
+}
+
+// trimWeighted is trim of weighted samples: the weights taken off the
+// fastest and off the slowest samples each add up to percent of the
+// total weight, so that a sample may be left in with part of its weight.
+func trimWeighted(s weightedSamples, percent float64) weightedSamples {
+	total := sumWeights(s.weights)
+	n := int(float64(total) * percent / 100)
+	n = min(n, (total-1)/2)
+	res := weightedSamples{values: s.values, weights: slices.Clone(s.weights)}
+	for i, left := 0, n; left > 0; i++ {
+		d := min(left, res.weights[i])
+		res.weights[i] -= d
+		left -= d
+	}
+	for i, left := len(res.weights)-1, n; left > 0; i-- {
+		d := min(left, res.weights[i])
+		res.weights[i] -= d
+		left -= d
+	}
+	return res
+}
+
+// sumWeights returns the total weight of the samples.
+func sumWeights(weights []int) int {
+	var total int
+	for _, w := range weights {
+		total += w
+	}
+	return total
+}
+
+// weightedStddev returns the population standard deviation of data,
+// each value counting as many times as its weight.
+func weightedStddev(data []float64, weights []int) float64 {
+	total := float64(sumWeights(weights))
+	if total < 2 {
+		return 0
+	}
+	m := weightedMean(data, weights)
+	var m2 float64
+	for i, v := range data {
+		m2 += float64(weights[i]) * (v - m) * (v - m)
+	}
+	return math.Sqrt(m2 / total)
+}
+
+// weightedPercentile returns the p-th percentile of the sorted samples,
+// each value counting as many times as its weight, by nearest rank.
+func weightedPercentile(s weightedSamples, p float64) float64 {
+	if len(s.values) == 0 {
+		return 0
+	}
+	total := sumWeights(s.weights)
+	rank := max(int64(math.Ceil(p/100*float64(total)-1e-9)), 1)
+	var seen int64
+	for i, v := range s.values {
+		seen += int64(s.weights[i])
+		if seen >= rank {
+			return v
+		}
+	}
+	return s.values[len(s.values)-1]
+}
+
+// cdf samples the empirical cumulative distribution function of the
+// sorted latencies at evenly spaced ranks.
+func (r *report) cdf(sorted []float64) []CDFPoint {
+	n := len(sorted)
+	k := r.cdfPoints
+	if k < 1 {
+		k = defaultCDFPoints
+	}
+	k = min(k, n)
+	res := make([]CDFPoint, k)
+	for i := range res {
+		rank := ((i+1)*n + k - 1) / k
+		res[i] = CDFPoint{
+			Latency:            sorted[rank-1],
+			CumulativeFraction: float64(rank) / float64(n),
 		}
 	}
 	return res
 }
 
+// mad returns the median absolute deviation of the sorted data from its
+// median. The deviations of the samples below the median, and of the ones
+// above, are already in order, so they are merged rather than sorted.
+func mad(sorted []float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	m := percentile(sorted, 50, LinearInterpolation)
+	// i walks down from the median and j up, taking the smallest
+	// deviation first, until the middle one(s).
+	j := sort.SearchFloat64s(sorted, m)
+	i := j - 1
+	var prev, cur float64
+	for k := 0; k <= n/2; k++ {
+		prev = cur
+		if i >= 0 && (j >= n || m-sorted[i] <= sorted[j]-m) {
+			cur = m - sorted[i]
+			i--
+		} else {
+			cur = sorted[j] - m
+			j++
+		}
+	}
+	if n%2 == 1 {
+		return cur
+	}
+	return (prev + cur) / 2
+}
+
+// trim returns the sorted data without its lowest and its highest
+// percent percents. At least one value is kept.
+func trim(sorted []float64, percent float64) []float64 {
+	n := int(float64(len(sorted)) * percent / 100)
+	n = min(n, (len(sorted)-1)/2)
+	return sorted[n : len(sorted)-n]
+}
+
+// iqMean returns the interquartile mean of the sorted data, the average
+// of its middle half. The quarters left out are rounded down, so that of
+// fewer than four values, all of them are averaged.
+func iqMean(sorted []float64) float64 {
+	mid := trim(sorted, 25)
+	var sum float64
+	for _, v := range mid {
+		sum += v
+	}
+	return mean(sum, len(mid))
+}
+
+// minCISamples is the number of samples from which the confidence
+// interval of the mean is reported. Below, the normal approximation of
+// the distribution of the mean is too rough.
+const minCISamples = 30
+
+// meanCI returns the 95% confidence interval of the mean of n samples
+// of the given standard deviation.
+func meanCI(mean, stddev float64, n int) (lo, hi float64) {
+	d := 1.96 * stddev / math.Sqrt(float64(n))
+	return mean - d, mean + d
+}
+
+// defaultBootstrapResamples is the number of bootstrap resamples if none
+// is set.
+const defaultBootstrapResamples = 1000
+
+// bootstrapPercentile returns the 2.5th and the 97.5th percentiles of
+// the p-th percentiles of resamples resamples, with replacement, of the
+// sorted data: a 95% confidence band of its p-th percentile. It sorts
+// each resample, so it is slow on large data.
+func bootstrapPercentile(sorted []float64, p float64, resamples int, rng *rand.Rand, method PercentileMethod) (lo, hi float64) {
+	if len(sorted) == 0 {
+		return 0, 0
+	}
+	estimates := make([]float64, resamples)
+	resample := make([]float64, len(sorted))
+	for i := range estimates {
+		for j := range resample {
+			resample[j] = sorted[rng.Intn(len(sorted))]
+		}
+		sort.Float64s(resample)
+		estimates[i] = percentile(resample, p, method)
+	}
+	sort.Float64s(estimates)
+	return percentile(estimates, 2.5, method), percentile(estimates, 97.5, method)
+}
+
+// stddev returns the population standard deviation of data, computed
+// in a single pass with Welford's algorithm.
+func stddev(data []float64) float64 {
+	if len(data) < 2 {
+		return 0
+	}
+	var mean, m2 float64
+	for i, v := range data {
+		delta := v - mean
+		mean += delta / float64(i+1)
+		m2 += delta * (v - mean)
+	}
+	return math.Sqrt(m2 / float64(len(data)))
+}
+
+// geoMean returns the geometric mean of the positive values of data. It
+// sums logarithms, so that the product of many values cannot overflow.
+func geoMean(data []float64) float64 {
+	var sum float64
+	var n int
+	for _, v := range data {
+		if v > 0 {
+			sum += math.Log(v)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Exp(sum / float64(n))
+}
+
+// arrivalCV returns the coefficient of variation of the gaps between the
+// successive offsets: zero for evenly paced requests, and larger the
+// burstier they are.
+func arrivalCV(offsets []float64) float64 {
+	if len(offsets) < 3 {
+		return 0
+	}
+	sorted := sortedCopy(offsets)
+	gaps := make([]float64, len(sorted)-1)
+	var sum float64
+	for i := range gaps {
+		gaps[i] = sorted[i+1] - sorted[i]
+		sum += gaps[i]
+	}
+	mean := sum / float64(len(gaps))
+	if mean == 0 {
+		return 0
+	}
+	return stddev(gaps) / mean
+}
+
+// concurrency returns the largest number of requests in flight at once
+// and the average number over the span of the requests, from their start
+// offsets and their latencies. A request ending when another one starts
+// does not overlap with it.
+func concurrency(offsets, lats []float64) (int, float64) {
+	if len(offsets) != len(lats) || len(lats) == 0 {
+		return 0, 0
+	}
+	type event struct {
+		at    float64
+		delta int
+	}
+	events := make([]event, 0, 2*len(lats))
+	for i, lat := range lats {
+		events = append(events, event{offsets[i], 1}, event{offsets[i] + lat, -1})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at != events[j].at {
+			return events[i].at < events[j].at
+		}
+		// Ends come first.
+		return events[i].delta < events[j].delta
+	})
+	var peak, inFlight int
+	var area float64
+	for i, e := range events {
+		if i > 0 {
+			area += float64(inFlight) * (e.at - events[i-1].at)
+		}
+		inFlight += e.delta
+		peak = max(peak, inFlight)
+	}
+	span := events[len(events)-1].at - events[0].at
+	if span <= 0 {
+		return peak, float64(peak)
+	}
+	return peak, area / span
+}
+
+// Error categories of ErrorCategoryDist.
+const (
+	ErrCategoryDNS     = "DNS failure"
+	ErrCategoryRefused = "connection refused"
+	ErrCategoryTimeout = "timeout"
+	ErrCategoryTLS     = "TLS error"
+	ErrCategoryEOF     = "EOF"
+	ErrCategoryOther   = "other"
+)
+
+// Phases of the requests of ErrorPhaseDist.
+const (
+	ErrPhaseDNS     = "dns"
+	ErrPhaseConnect = "connect"
+	ErrPhaseTLS     = "tls"
+	ErrPhaseWrite   = "write"
+	ErrPhaseRead    = "read"
+)
+
+// classifyError returns the category of a request error.
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrCategoryDNS
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrCategoryTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrCategoryRefused
+	}
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &invalidErr) || errors.As(err, &hostnameErr) {
+		return ErrCategoryTLS
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrCategoryEOF
+	}
+	return ErrCategoryOther
+}
+
+// PercentileMethod selects how a percentile is derived from the samples.
+type PercentileMethod int
+
+const (
+	// NearestRank reports the smallest sample such that at least p percent
+	// of the samples are less than or equal to it. The reported value is
+	// always one of the observed samples.
+	NearestRank PercentileMethod = iota
+
+	// LinearInterpolation interpolates linearly between the two samples
+	// closest to the exact rank (p/100)*(n-1).
+	LinearInterpolation
+)
+
+// percentile returns the p-th percentile of the sorted data.
+func percentile(sorted []float64, p float64, method PercentileMethod) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	switch method {
+	case LinearInterpolation:
+		h := p / 100 * float64(n-1)
+		lo := int(math.Floor(h))
+		if lo >= n-1 {
+			return sorted[n-1]
+		}
+		if lo < 0 {
+			return sorted[0]
+		}
+		return sorted[lo] + (h-float64(lo))*(sorted[lo+1]-sorted[lo])
+	default:
+		// Subtract a small epsilon so that ranks which are whole numbers
+		// are not pushed up by floating point error, e.g. 99.9% of 1000.
+		rank := int(math.Ceil(p/100*float64(n) - 1e-9))
+		if rank < 1 {
+			rank = 1
+		}
+		if rank > n {
+			rank = n
+		}
+		return sorted[rank-1]
+	}
+}
+
+// errorsPerSecond returns the number of failed requests started in each
+// second of the run, given their offsets in seconds.
+func errorsPerSecond(offsets []float64) []int {
+	var counts []int
+	for _, offset := range offsets {
+		s := int(offset)
+		for len(counts) <= s {
+			counts = append(counts, 0)
+		}
+		counts[s]++
+	}
+	return counts
+}
+
+// throughput buckets the samples into windows by their offset.
+func (r *report) throughput() []ThroughputPoint {
+	window := r.throughputWindow
+	if window < 1 {
+		window = 1
+	}
+	var points []ThroughputPoint
+	for i, offset := range r.offsets {
+		w := int(offset) / window
+		for len(points) <= w {
+			points = append(points, ThroughputPoint{Second: len(points) * window})
+		}
+		points[w].Count++
+		points[w].AvgLatency += r.lats[i]
+		points[w].Bytes += r.sizes[i]
+	}
+	for i := range points {
+		if points[i].Count > 0 {
+			points[i].AvgLatency /= float64(points[i].Count)
+		}
+	}
+	return points
+}
+
+// statusLatencies computes the latency statistics of each status code.
+func (r *report) statusLatencies() map[int]StatusLatency {
+	byCode := make(map[int][]float64)
+	for i, code := range r.statusCodes {
+		if code == 0 && r.unknownStatus {
+			continue
+		}
+		byCode[code] = append(byCode[code], r.lats[i])
+	}
+	res := make(map[int]StatusLatency, len(byCode))
+	for code, lats := range byCode {
+		var sum float64
+		for _, v := range lats {
+			sum += v
+		}
+		sort.Float64s(lats)
+		res[code] = StatusLatency{
+			Count:   len(lats),
+			Average: sum / float64(len(lats)),
+			P95:     percentile(lats, 95, r.pctlMethod),
+		}
+	}
+	return res
+}
+
+// phaseHistogramsOf returns the latency histogram of each phase of the
+// sorted samples.
+func (r *report) phaseHistogramsOf(sorted *samples) map[string][]Bucket {
+	phases := map[string][]float64{
+		"conn":  sorted.connLats,
+		"dns":   sorted.dnsLats,
+		"tls":   sorted.tlsLats,
+		"req":   sorted.reqLats,
+		"delay": sorted.delayLats,
+		"res":   sorted.resLats,
+		"ttfb":  sorted.ttfbLats,
+	}
+	res := make(map[string][]Bucket, len(phases))
+	for phase, lats := range phases {
+		if len(lats) > 0 {
+			res[phase] = r.histogram(lats)
+		}
+	}
+	return res
+}
+
+// histogram returns the histogram of the sorted data, whose buckets
+// span from its fastest to its slowest value. It only depends on the
+// report for the number and the spacing of the buckets.
 func (r *report) histogram(data []float64) []Bucket {
-	bc := 10
+	if len(data) == 0 {
+		return nil
+	}
+	if len(r.bucketBounds) > 0 {
+		return boundedHistogram(data, r.bucketBounds)
+	}
+	fastest, slowest := data[0], data[len(data)-1]
+	if slowest == fastest {
+		// All the samples have the same latency, there is nothing to
+		// spread over the buckets.
+		return []Bucket{{
+			Mark:      fastest,
+			Count:     len(data),
+			Frequency: 1,
+		}}
+	}
+	bc := r.histogramBuckets
+	if bc < 1 {
+		bc = defaultHistogramBuckets
+	}
 	buckets := make([]float64, bc+1)
 	counts := make([]int, bc+1)
-	bs := (r.slowest - r.fastest) / float64(bc)
-	for i := 0; i < bc; i++ {
-		buckets[i] = r.fastest + bs*float64(i)
+	if lo := math.Max(fastest, minLogMark); r.logHistogram && lo < slowest {
+		// Space the marks geometrically, so that a long tail does not
+		// squeeze most of the samples into the first bucket.
+		ratio := math.Pow(slowest/lo, 1/float64(bc))
+		for i := 0; i < bc; i++ {
+			buckets[i] = lo * math.Pow(ratio, float64(i))
+		}
+	} else {
+		bs := (slowest - fastest) / float64(bc)
+		for i := 0; i < bc; i++ {
+			buckets[i] = fastest + bs*float64(i)
+		}
 	}
-	buckets[bc] = r.slowest
+	buckets[bc] = slowest
+	// Every sample is counted, in the last bucket at the latest, so that
+	// the frequencies add up to 1.
 	var bi int
-	var maximum int
-	for i := 0; i < len(data); {
-		if data[i] <= buckets[bi] {
-			i++
-			counts[bi]++
-			if maximum < counts[bi] {
-				maximum = counts[bi]
-			}
-		} else if bi < len(buckets)-1 {
+	for _, v := range data {
+		for bi < bc && v > buckets[bi] {
 			bi++
 		}
+		counts[bi]++
 	}
 	res := make([]Bucket, len(buckets))
 	for i := 0; i < len(buckets); i++ {
 		res[i] = Bucket{
 			Mark:      buckets[i],
 			Count:     counts[i],
-			Frequency: float64(counts[i]) / float64(len(r.lats)),
+			Frequency: float64(counts[i]) / float64(len(data)),
+		}
+	}
+	return res
+}
+
+// boundedHistogram returns the histogram of the sorted data over the
+// ascending bucket bounds, followed by an overflow bucket of the samples
+// above the highest bound. Its mark is the slowest sample, or the
+// highest bound if no sample is above it.
+func boundedHistogram(data, bounds []float64) []Bucket {
+	res := make([]Bucket, len(bounds)+1)
+	for i, b := range bounds {
+		res[i].Mark = b
+	}
+	res[len(bounds)] = Bucket{
+		Mark:     max(bounds[len(bounds)-1], data[len(data)-1]),
+		Overflow: true,
+	}
+	var bi int
+	for _, v := range data {
+		for bi < len(bounds) && v > bounds[bi] {
+			bi++
 		}
+		res[bi].Count++
+	}
+	for i := range res {
+		res[i].Frequency = float64(res[i].Count) / float64(len(data))
 	}
 	return res
 }
 
+// modeBucket returns the index of the most populated bucket of the
+// histogram, the first one if several are, and the range of latencies
+// it covers: from the mark of the previous bucket to its own.
+func modeBucket(buckets []Bucket) (int, [2]float64) {
+	if len(buckets) == 0 {
+		return 0, [2]float64{}
+	}
+	mode := 0
+	for i, b := range buckets {
+		if b.Count > buckets[mode].Count {
+			mode = i
+		}
+	}
+	return mode, bucketRange(buckets, mode)
+}
+
+// bucketRange returns the range of latencies of the i-th bucket of the
+// histogram: from the mark of the previous bucket to its own.
+func bucketRange(buckets []Bucket, i int) [2]float64 {
+	lo := buckets[i].Mark
+	if i > 0 {
+		lo = buckets[i-1].Mark
+	}
+	return [2]float64{lo, buckets[i].Mark}
+}
+
+const (
+	// bimodalMinPeak is the smallest fraction of the samples in the
+	// second peak of a bimodal histogram, so that a few outliers in the
+	// tail are not taken for a peak.
+	bimodalMinPeak = 0.05
+	// bimodalValley is the largest count of the valley between the two
+	// peaks of a bimodal histogram, relative to the lower peak.
+	bimodalValley = 0.5
+)
+
+// bimodalRanges returns the latency ranges of the two peaks of the
+// histogram, in increasing order, if it has two peaks separated by a
+// valley. The first peak is the mode; the second one is the most
+// populated bucket with at least bimodalMinPeak of the samples such
+// that the least populated bucket between the two, the valley, holds
+// less than bimodalValley of its count. It returns nil if there is no
+// such bucket.
+func bimodalRanges(buckets []Bucket) [][2]float64 {
+	if len(buckets) < 3 {
+		return nil
+	}
+	mode, _ := modeBucket(buckets)
+	second := -1
+	for i, b := range buckets {
+		if i == mode || b.Frequency < bimodalMinPeak {
+			continue
+		}
+		if second >= 0 && b.Count <= buckets[second].Count {
+			continue
+		}
+		lo, hi := min(i, mode), max(i, mode)
+		valley := b.Count
+		for _, v := range buckets[lo+1 : hi] {
+			valley = min(valley, v.Count)
+		}
+		if hi-lo > 1 && float64(valley) < bimodalValley*float64(b.Count) {
+			second = i
+		}
+	}
+	if second < 0 {
+		return nil
+	}
+	lo, hi := min(second, mode), max(second, mode)
+	return [][2]float64{bucketRange(buckets, lo), bucketRange(buckets, hi)}
+}
+
+// Report is a snapshot of the results of a run. Latencies are in seconds.
+// In JSON output, Total is encoded as an integer number of nanoseconds.
 type Report struct {
 	AvgTotal float64
 	Fastest  float64
 	Slowest  float64
 	Average  float64
+	GeoMean  float64
+	Median   float64
+	Stddev   float64
 	Rps      float64
+	// Goodput is the rate of the successful 2xx and 3xx responses, unlike
+	// Rps of all the requests, including the failed ones.
+	Goodput float64
 
 	AvgConn  float64
 	AvgDNS   float64
@@ -298,6 +2068,7 @@ type Report struct {
 	AvgReq   float64
 	AvgRes   float64
 	AvgDelay float64
+	AvgTTFB  float64
 	ConnMax  float64
 	ConnMin  float64
 	DnsMax   float64
@@ -310,6 +2081,8 @@ type Report struct {
 	ResMin   float64
 	DelayMax float64
 	DelayMin float64
+	TtfbMax  float64
+	TtfbMin  float64
 
 	Lats        []float64
 	ConnLats    []float64
@@ -318,23 +2091,221 @@ type Report struct {
 	ReqLats     []float64
 	ResLats     []float64
 	DelayLats   []float64
+	TtfbLats    []float64
 	Offsets     []float64
 	StatusCodes []int
 
 	Total time.Duration
+	// StartTime and EndTime are the wall-clock times of the start and
+	// the end of the run. They are zero in reports from samples.
+	StartTime time.Time
+	EndTime   time.Time
 
 	ErrorDist      map[string]int
 	StatusCodeDist map[int]int
 	SizeTotal      int64
 	SizeReq        int64
+	SizeMin        int64
+	SizeMax        int64
 	NumRes         int64
 
 	LatencyDistribution []LatencyDistribution
 	Histogram           []Bucket
+
+	// PhaseHistograms are the latency histograms of each phase of the
+	// requests, by phase: "conn", "dns", "tls", "req", "delay", "res"
+	// and "ttfb". They are only computed if asked for.
+	PhaseHistograms map[string][]Bucket
+
+	// ModeBucketIndex is the index of the most populated bucket of the
+	// histogram, and ModeBucketRange the latencies it covers.
+	ModeBucketIndex int
+	ModeBucketRange [2]float64
+
+	// Bimodal is set if the histogram has two peaks separated by a
+	// valley, e.g. of cache hits and misses, and ModalRanges are then the
+	// latencies of the two peaks, the lower one first.
+	Bimodal     bool
+	ModalRanges [][2]float64
+
+	// StatusLatencies are the latency statistics of each status code.
+	StatusLatencies map[int]StatusLatency
+
+	// Throughput is the number of requests started in each window of
+	// the run, in chronological order.
+	Throughput []ThroughputPoint
+
+	// ErrorRate is the fraction of the requests that failed.
+	ErrorRate float64
+
+	// ErrorCategoryDist is the number of errors by category, see
+	// classifyError. ErrorDist holds the number of errors by message.
+	ErrorCategoryDist map[string]int
+
+	// ErrorPhaseDist is the number of errors by the phase of the request
+	// they occurred in, one of "dns", "connect", "tls", "write" and
+	// "read", which covers the wait for the response and reading it.
+	ErrorPhaseDist map[string]int
+
+	// ErrorsPerSecond is the number of failed requests started in each
+	// second of the run, e.g. to line errors up with a restart of the
+	// target. ErrorsPerSecond[i] covers [i, i+1) seconds.
+	ErrorsPerSecond []int
+
+	// FirstErrorOffset and LastErrorOffset are the offsets of the first
+	// and the last failed request, in seconds since the start of the
+	// run. Both are -1 if no request failed.
+	FirstErrorOffset float64
+	LastErrorOffset  float64
+
+	// NumWarmup is the number of requests discarded because they were
+	// started during the warmup. They are not part of any other statistic.
+	NumWarmup int64
+
+	// LatencySketch is an encoded quantile sketch of the latencies, if
+	// Work.LatencySketch is set or the output is "sketch". The sketches
+	// of several runs can be merged with MergeSketches, and their
+	// percentiles computed with SketchPercentile.
+	LatencySketch []byte
+
+	// Partial is set if the run was interrupted. The report covers the
+	// requests made until then.
+	Partial bool
+
+	// OutputMode is the output the report was made for, e.g. "json", or
+	// the template of the summary. It is empty for the default summary.
+	OutputMode string
+
+	// RunID is a random identifier of the run, e.g. to tell the runs
+	// appended to the same file apart.
+	RunID string
+
+	// NumErrs is the number of failed requests.
+	NumErrs int64
+
+	// ConnNew and ConnReused are the number of successful requests
+	// made on a new connection and on a kept-alive connection.
+	ConnNew    int64
+	ConnReused int64
+	// DistinctConnections is the number of distinct connections the
+	// requests were made on, i.e. the concurrency achieved at the socket
+	// level, e.g. fewer than the workers with HTTP/2.
+	DistinctConnections int
+
+	// SlowestN are the slowest requests, slowest first.
+	SlowestN []SlowRequest
+
+	// Apdex is the Apdex score of the successful requests for the
+	// threshold ApdexT, in seconds. Both are zero if no threshold is set.
+	Apdex  float64
+	ApdexT float64
+
+	// SampledCount is the number of successful requests whose samples
+	// were retained, and DroppedCount the number of them that were not
+	// because there were more than the maximum. The distribution of the
+	// latencies covers the retained samples only.
+	SampledCount int64
+	DroppedCount int64
+
+	// ArrivalCV is the coefficient of variation of the gaps between the
+	// starts of the successive requests. It is close to zero if the
+	// requests were evenly paced, and above one if they came in bursts.
+	ArrivalCV float64
+
+	// PeakConcurrency and AvgConcurrency are the largest and the average
+	// number of the successful requests in flight at once, from the first
+	// start to the last end, i.e. the concurrency the run sustained.
+	PeakConcurrency int
+	AvgConcurrency  float64
+
+	// StatusCodeCounts is StatusCodeDist sorted by status code.
+	StatusCodeCounts []StatusCodeCount
+
+	// SLOResults are the outcomes of the SLO rules of the run.
+	SLOResults []SLOResult
+
+	// CDF is the empirical cumulative distribution function of the
+	// latencies, sampled at evenly spaced fractions.
+	CDF []CDFPoint
+
+	// ReqSizeTotal is the total size of the bodies of the successful
+	// requests, and MBPerSec the rate of the data sent and received, in
+	// megabytes (10^6 bytes) per second.
+	ReqSizeTotal int64
+	MBPerSec     float64
+
+	// TotalAttempts is the number of attempts of the requests, including
+	// retries, and RetriedRequests the number of requests that took more
+	// than one attempt. TotalAttempts - NumRes is the load added by retries.
+	TotalAttempts   int64
+	RetriedRequests int64
+
+	// P99P50Ratio is the 99th percentile of the latencies divided by the
+	// median, zero if the median is, and IQR the interquartile range of
+	// the latencies. A high ratio flags a bimodal or degrading service.
+	P99P50Ratio float64
+	IQR         float64
+
+	// NormalizedDistribution are the percentiles of LatencyDistribution
+	// as multiples of the median, which compares services of different
+	// speeds. It is empty if the median is zero.
+	NormalizedDistribution []NormalizedPercentile
+
+	// DelayFraction is the fraction of the average latency spent waiting
+	// for the response once the request is written, i.e. on the
+	// processing of the target, and DelayDominated is set if it exceeds
+	// 30%. The time to get a connection is part of AvgConn instead.
+	DelayFraction  float64
+	DelayDominated bool
+
+	// MAD is the median absolute deviation of the latencies from their
+	// median. Unlike Stddev, a few outliers barely affect it.
+	MAD float64
+
+	// IQMean is the interquartile mean of the latencies, the average of
+	// those between the 25th and the 75th percentile. Like MAD, it
+	// barely moves with a few outliers.
+	IQMean float64
+
+	// MeanCILow and MeanCIHigh are the bounds of the 95% confidence
+	// interval of Average, of at least 30 samples, a sample counting as
+	// many times as its weight. Both are zero with fewer samples.
+	MeanCILow  float64
+	MeanCIHigh float64
+
+	// P95CILow and P95CIHigh are the bounds of the 95% confidence band of
+	// the p95 latency, estimated by bootstrap resampling if asked for,
+	// and zero otherwise.
+	P95CILow  float64
+	P95CIHigh float64
+
+	// SortedLats are the latencies of Lats sorted in ascending order,
+	// so that callers need not sort them again. Lats is in arrival
+	// order. SortedLats is left out of the JSON output, which has Lats.
+	SortedLats []float64 `json:"-"`
+
+	// TargetRps is the requested rate of the run, zero if it was not
+	// limited, and RpsAchievedPct the percentage of it that Rps achieved.
+	TargetRps      float64
+	RpsAchievedPct float64
+}
+
+// FractionUnder returns the fraction of the responses whose latency, in
+// seconds, is at or below latency; the inverse of a percentile.
+func (rep Report) FractionUnder(latency float64) float64 {
+	lats := rep.SortedLats
+	if len(lats) == 0 {
+		lats = sortedCopy(rep.Lats)
+	}
+	if len(lats) == 0 {
+		return 0
+	}
+	n := sort.Search(len(lats), func(i int) bool { return lats[i] > latency })
+	return float64(n) / float64(len(lats))
 }
 
 type LatencyDistribution struct {
-	Percentage   int
+	Percentage   float64
 	Latency      float64
 	DnsLatency   float64
 	ConnLatency  float64
@@ -342,10 +2313,76 @@ type LatencyDistribution struct {
 	DelayLatency float64
 	ReqLatency   float64
 	RespLatency  float64
+	TtfbLatency  float64
+}
+
+// NormalizedPercentile is a percentile of the latencies relative to
+// their median, e.g. 8.3 for a 99th percentile of 8.3 times the median.
+type NormalizedPercentile struct {
+	Percentage    float64
+	RatioToMedian float64
+}
+
+// normalizedDistribution returns the latencies of dist divided by the
+// median, or nil if the median is zero.
+func normalizedDistribution(dist []LatencyDistribution, median float64) []NormalizedPercentile {
+	if median <= 0 || len(dist) == 0 {
+		return nil
+	}
+	res := make([]NormalizedPercentile, len(dist))
+	for i, d := range dist {
+		res[i] = NormalizedPercentile{Percentage: d.Percentage, RatioToMedian: d.Latency / median}
+	}
+	return res
+}
+
+// StatusLatency holds the latency statistics of the responses
+// with a given status code.
+type StatusLatency struct {
+	Count   int
+	Average float64
+	P95     float64
+}
+
+// ThroughputPoint holds the requests started in a window of the run.
+type ThroughputPoint struct {
+	// Second is the start of the window, in seconds since the start of the run.
+	Second     int
+	Count      int
+	AvgLatency float64
+	// Bytes is the size of the responses of the requests of the window.
+	Bytes int64
+}
+
+// CDFPoint is a point of the cumulative distribution function: the
+// fraction of the latencies that are at most Latency.
+type CDFPoint struct {
+	Latency            float64
+	CumulativeFraction float64
+}
+
+// StatusCodeCount is the number of responses with a status code.
+type StatusCodeCount struct {
+	Code  int
+	Count int
+	// Class is the class of the code, e.g. "2xx", or "error" if there
+	// was no response.
+	Class string
+}
+
+// SlowRequest is one of the slowest requests of the run.
+type SlowRequest struct {
+	Latency float64
+	// Offset is the start of the request, in seconds since the start of the run.
+	Offset     float64
+	StatusCode int
 }
 
 type Bucket struct {
 	Mark      float64
 	Count     int
 	Frequency float64
+	// Overflow is set on the last bucket of a histogram with fixed
+	// bounds, which holds the samples above the highest bound.
+	Overflow bool
 }
@@ -0,0 +1,2406 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestReport returns a report whose results channel is large enough
+// to hold n results without a running reporter.
+func newTestReport(n int) *report {
+	return newReport(io.Discard, make(chan *result, n), "", n, 0)
+}
+
+// feed sends the results to the report and runs the reporter until
+// the results are consumed.
+func feed(r *report, results ...*result) {
+	for _, res := range results {
+		r.results <- res
+	}
+	close(r.results)
+	runReporter(r)
+}
+
+func ms(v float64) time.Duration {
+	return time.Duration(v * float64(time.Millisecond))
+}
+
+func TestSnapshotPhaseMinMax(t *testing.T) {
+	r := newTestReport(3)
+	feed(r,
+		&result{statusCode: 200, duration: ms(30), connDuration: ms(2), dnsDuration: ms(5), tlsDuration: ms(7), reqDuration: ms(1), delayDuration: ms(20), resDuration: ms(3)},
+		&result{statusCode: 200, duration: ms(10), connDuration: ms(9), dnsDuration: ms(1), tlsDuration: ms(3), reqDuration: ms(4), delayDuration: ms(2), resDuration: ms(6)},
+		&result{statusCode: 200, duration: ms(20), connDuration: ms(4), dnsDuration: ms(3), tlsDuration: ms(1), reqDuration: ms(2), delayDuration: ms(8), resDuration: ms(1)},
+	)
+	s := r.snapshot()
+
+	tests := []struct {
+		name     string
+		min, max float64
+		wantMin  float64
+		wantMax  float64
+	}{
+		{"conn", s.ConnMin, s.ConnMax, 0.002, 0.009},
+		{"dns", s.DnsMin, s.DnsMax, 0.001, 0.005},
+		{"tls", s.TlsMin, s.TlsMax, 0.001, 0.007},
+		{"req", s.ReqMin, s.ReqMax, 0.001, 0.004},
+		{"delay", s.DelayMin, s.DelayMax, 0.002, 0.020},
+		{"res", s.ResMin, s.ResMax, 0.001, 0.006},
+		{"total", s.Fastest, s.Slowest, 0.010, 0.030},
+	}
+	for _, tt := range tests {
+		if !approx(tt.min, tt.wantMin) || !approx(tt.max, tt.wantMax) {
+			t.Errorf("%s: got min %v, max %v; want min %v, max %v", tt.name, tt.min, tt.max, tt.wantMin, tt.wantMax)
+		}
+	}
+}
+
+func approx(got, want float64) bool {
+	const epsilon = 1e-9
+	d := got - want
+	return d < epsilon && d > -epsilon
+}
+
+func TestLatenciesPercentileMethods(t *testing.T) {
+	seq := func(n int) []float64 {
+		data := make([]float64, n)
+		for i := range data {
+			data[i] = float64(i + 1)
+		}
+		return data
+	}
+	tests := []struct {
+		name   string
+		data   []float64
+		method PercentileMethod
+		want   []float64 // p10, p25, p50, p75, p90, p95, p99 and p99.9 from 1000 samples
+	}{
+		{"1/nearest", []float64{0.5}, NearestRank, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}},
+		{"1/linear", []float64{0.5}, LinearInterpolation, []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}},
+		{"7/nearest", seq(7), NearestRank, []float64{1, 2, 4, 6, 7, 7, 7}},
+		{"7/linear", seq(7), LinearInterpolation, []float64{1.6, 2.5, 4, 5.5, 6.4, 6.7, 6.94}},
+		{"1000/nearest", seq(1000), NearestRank, []float64{100, 250, 500, 750, 900, 950, 990, 999}},
+		{"1000/linear", seq(1000), LinearInterpolation, []float64{100.9, 250.75, 500.5, 750.25, 900.1, 950.05, 990.01, 999.001}},
+	}
+	for _, tt := range tests {
+		r := newTestReport(0)
+		r.pctlMethod = tt.method
+		got := r.latencies(&samples{lats: tt.data})
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %d percentiles; want %d", tt.name, len(got), len(tt.want))
+		}
+		for i, ld := range got {
+			if d := ld.Latency - tt.want[i]; d > 1e-6 || d < -1e-6 {
+				t.Errorf("%s: p%v = %v; want %v", tt.name, ld.Percentage, ld.Latency, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestLatenciesKeepsTinyLatencies(t *testing.T) {
+	r := newTestReport(0)
+	got := r.latencies(&samples{lats: []float64{0, 0, 1e-9}})
+	if len(got) != 7 {
+		t.Fatalf("got %d percentiles; want 7", len(got))
+	}
+	for _, ld := range got {
+		if ld.Percentage == 0 {
+			t.Errorf("percentile row was dropped: %+v", ld)
+		}
+	}
+}
+
+func TestLatenciesCustomPercentiles(t *testing.T) {
+	data := make([]float64, 1000)
+	for i := range data {
+		data[i] = float64(i + 1)
+	}
+	r := newTestReport(0)
+	r.percentiles = []float64{50, 99.9}
+	got := r.latencies(&samples{lats: data})
+	want := []LatencyDistribution{
+		{Percentage: 50, Latency: 500},
+		{Percentage: 99.9, Latency: 999},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d percentiles; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Percentage != want[i].Percentage || got[i].Latency != want[i].Latency {
+			t.Errorf("got %+v; want %+v", got[i], want[i])
+		}
+	}
+
+	r.percentiles = nil
+	if got := r.latencies(&samples{lats: data}); len(got) != len(defaultPercentiles)+1 {
+		t.Errorf("got %d default percentiles of %d samples; want %d with p99.9", len(got), len(data), len(defaultPercentiles)+1)
+	}
+}
+
+func TestLatenciesTailPercentiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]float64, 10000)
+	for i := range data {
+		data[i] = rng.ExpFloat64()
+	}
+	sort.Float64s(data)
+	r := newTestReport(0)
+	pctls := map[float64]float64{}
+	for _, d := range r.latencies(&samples{lats: data}) {
+		pctls[d.Percentage] = d.Latency
+	}
+	p999, ok := pctls[99.9]
+	if !ok || p999 < pctls[99] {
+		t.Errorf("p99.9 = %v, %v; want it, at least p99 = %v", p999, ok, pctls[99])
+	}
+	if p9999, ok := pctls[99.99]; !ok || p9999 < p999 {
+		t.Errorf("p99.99 = %v, %v; want it, at least p99.9 = %v", p9999, ok, p999)
+	}
+
+	// 999 samples are not enough for p99.9, which would be the slowest.
+	for _, d := range r.latencies(&samples{lats: data[:999]}) {
+		if d.Percentage > 99 {
+			t.Errorf("p%v is reported of 999 samples; want it omitted", d.Percentage)
+		}
+	}
+}
+
+func TestLatenciesPhaseBreakdown(t *testing.T) {
+	r := newTestReport(4)
+	var results []*result
+	for i := 1; i <= 4; i++ {
+		d := float64(i)
+		results = append(results, &result{
+			statusCode:    200,
+			duration:      ms(21 * d),
+			dnsDuration:   ms(1 * d),
+			connDuration:  ms(2 * d),
+			tlsDuration:   ms(3 * d),
+			reqDuration:   ms(4 * d),
+			delayDuration: ms(5 * d),
+			resDuration:   ms(6 * d),
+		})
+	}
+	feed(r, results...)
+	s := r.snapshot()
+	if len(s.LatencyDistribution) == 0 {
+		t.Fatal("latency distribution is empty")
+	}
+	for _, ld := range s.LatencyDistribution {
+		fields := map[string]float64{
+			"Latency":      ld.Latency,
+			"DnsLatency":   ld.DnsLatency,
+			"ConnLatency":  ld.ConnLatency,
+			"TlsLatency":   ld.TlsLatency,
+			"ReqLatency":   ld.ReqLatency,
+			"DelayLatency": ld.DelayLatency,
+			"RespLatency":  ld.RespLatency,
+		}
+		for name, v := range fields {
+			if v <= 0 {
+				t.Errorf("p%v: %s = %v; want > 0", ld.Percentage, name, v)
+			}
+		}
+	}
+	// The 50th percentile of four samples is the second sample.
+	p50 := s.LatencyDistribution[2]
+	if !approx(p50.DelayLatency, 0.010) || !approx(p50.RespLatency, 0.012) {
+		t.Errorf("p50 delay = %v, resp = %v; want 0.010, 0.012", p50.DelayLatency, p50.RespLatency)
+	}
+}
+
+func TestStddev(t *testing.T) {
+	tests := []struct {
+		data []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{3}, 0},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 2},
+	}
+	for _, tt := range tests {
+		got := stddev(tt.data)
+		if math.IsNaN(got) || !approx(got, tt.want) {
+			t.Errorf("stddev(%v) = %v; want %v", tt.data, got, tt.want)
+		}
+	}
+
+	r := newTestReport(8)
+	var results []*result
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		results = append(results, &result{statusCode: 200, duration: ms(v)})
+	}
+	feed(r, results...)
+	if got := r.snapshot().Stddev; !approx(got, 0.002) {
+		t.Errorf("Stddev = %v; want 0.002", got)
+	}
+}
+
+func TestGeoMean(t *testing.T) {
+	tests := []struct {
+		data []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{0}, 0},
+		{[]float64{0.5}, 0.5},
+		// The cube root of 0.001 * 0.01 * 0.1 is 0.01.
+		{[]float64{0.001, 0.01, 0.1}, 0.01},
+		{[]float64{0.001, 0, 0.01, 0.1}, 0.01},
+		// 300 values of 1e-3 multiply to 1e-900, below the smallest float.
+		{repeat(1e-3, 300), 1e-3},
+	}
+	for _, tt := range tests {
+		if got := geoMean(tt.data); !approx(got, tt.want) {
+			t.Errorf("geoMean(%v) = %v; want %v", tt.data, got, tt.want)
+		}
+	}
+
+	r := newTestReport(2)
+	feed(r, &result{statusCode: 200, duration: ms(2)}, &result{statusCode: 200, duration: ms(8)})
+	if got := r.snapshot().GeoMean; !approx(got, 0.004) {
+		t.Errorf("GeoMean = %v; want 0.004", got)
+	}
+}
+
+func repeat(v float64, n int) []float64 {
+	data := make([]float64, n)
+	for i := range data {
+		data[i] = v
+	}
+	return data
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		lats []float64
+		want float64
+	}{
+		{[]float64{30, 10, 20}, 0.020},
+		{[]float64{40, 10, 30, 20}, 0.025},
+		{[]float64{7}, 0.007},
+	}
+	for _, tt := range tests {
+		r := newTestReport(len(tt.lats))
+		var results []*result
+		for _, v := range tt.lats {
+			results = append(results, &result{statusCode: 200, duration: ms(v)})
+		}
+		feed(r, results...)
+		if got := r.snapshot().Median; !approx(got, tt.want) {
+			t.Errorf("median of %v ms = %v; want %v", tt.lats, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	r := newTestReport(0)
+	r.histogramBuckets = 20
+	r.lats = []float64{1, 1.5, 2.5, 3, 4, 5}
+	buckets := r.histogram(r.lats)
+	if len(buckets) != 21 {
+		t.Fatalf("got %d marks; want 21", len(buckets))
+	}
+	if buckets[0].Mark != 1 || buckets[20].Mark != 5 {
+		t.Errorf("marks span [%v, %v]; want [1, 5]", buckets[0].Mark, buckets[20].Mark)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if w := buckets[i].Mark - buckets[i-1].Mark; !approx(w, 0.2) {
+			t.Errorf("bucket %d has width %v; want 0.2", i, w)
+		}
+	}
+	var count int
+	for _, b := range buckets {
+		count += b.Count
+	}
+	if count != len(r.lats) {
+		t.Errorf("buckets hold %d samples; want %d", count, len(r.lats))
+	}
+
+	r.histogramBuckets = 0
+	if got := len(r.histogram(r.lats)); got != defaultHistogramBuckets+1 {
+		t.Errorf("got %d marks by default; want %d", got, defaultHistogramBuckets+1)
+	}
+}
+
+func TestHistogramIdenticalLatencies(t *testing.T) {
+	var results []*result
+	for i := 0; i < 100; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(20)})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	s := r.snapshot()
+	want := []Bucket{{Mark: 0.020, Count: 100, Frequency: 1}}
+	if !reflect.DeepEqual(s.Histogram, want) {
+		t.Errorf("Histogram = %+v; want %+v", s.Histogram, want)
+	}
+	if s.ModeBucketIndex != 0 || s.ModeBucketRange != [2]float64{0.020, 0.020} {
+		t.Errorf("mode bucket = %d %v; want 0 [0.02 0.02]", s.ModeBucketIndex, s.ModeBucketRange)
+	}
+}
+
+func TestHistogramOfData(t *testing.T) {
+	r := newTestReport(0)
+	r.histogramBuckets = 4
+	// The report samples must not leak into the histograms.
+	r.lats = []float64{100, 200}
+	r.fastest, r.slowest = 100, 200
+
+	a := r.histogram([]float64{1, 2, 2, 3, 5})
+	b := r.histogram([]float64{10, 30, 50})
+	wantA := []Bucket{
+		{Mark: 1, Count: 1, Frequency: 0.2},
+		{Mark: 2, Count: 2, Frequency: 0.4},
+		{Mark: 3, Count: 1, Frequency: 0.2},
+		{Mark: 4, Count: 0, Frequency: 0},
+		{Mark: 5, Count: 1, Frequency: 0.2},
+	}
+	wantB := []Bucket{
+		{Mark: 10, Count: 1, Frequency: 1.0 / 3},
+		{Mark: 20, Count: 0, Frequency: 0},
+		{Mark: 30, Count: 1, Frequency: 1.0 / 3},
+		{Mark: 40, Count: 0, Frequency: 0},
+		{Mark: 50, Count: 1, Frequency: 1.0 / 3},
+	}
+	if !reflect.DeepEqual(a, wantA) {
+		t.Errorf("histogram of a = %+v; want %+v", a, wantA)
+	}
+	if !reflect.DeepEqual(b, wantB) {
+		t.Errorf("histogram of b = %+v; want %+v", b, wantB)
+	}
+	if got := r.histogram(nil); got != nil {
+		t.Errorf("histogram(nil) = %+v; want nil", got)
+	}
+}
+
+func TestHistogramFrequenciesSumToOne(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]float64, 1000)
+	for i := range random {
+		random[i] = rng.ExpFloat64() / 10
+	}
+	sort.Float64s(random)
+	datasets := map[string][]float64{
+		"random":         random,
+		"slowest ties":   {0.1, 0.2, 0.3, 0.3, 0.3},
+		"one sample":     {0.1},
+		"thirds":         {0.1, 0.2, 0.3},
+		"inexact width":  {0.1, 0.1 + 1e-12, 0.7, 0.7000000001},
+		"all but one":    {0.5, 0.5, 0.5, 0.5, 0.9},
+		"below log mark": {0, 1e-9, 0.2},
+	}
+	for name, data := range datasets {
+		for _, hist := range []struct {
+			name string
+			set  func(r *report)
+		}{
+			{"linear", func(r *report) {}},
+			{"log", func(r *report) { r.logHistogram = true }},
+			{"bounded", func(r *report) { r.bucketBounds = []float64{0.1, 0.25} }},
+			{"3 buckets", func(r *report) { r.histogramBuckets = 3 }},
+		} {
+			r := newTestReport(0)
+			hist.set(r)
+			var sum float64
+			var count int
+			for _, b := range r.histogram(data) {
+				sum += b.Frequency
+				count += b.Count
+			}
+			if count != len(data) || math.Abs(sum-1) > 1e-12 {
+				t.Errorf("%s, %s: %d samples with a frequency of %v; want %d and 1", name, hist.name, count, sum, len(data))
+			}
+		}
+	}
+}
+
+func TestPhaseHistograms(t *testing.T) {
+	// A bimodal connection setup, either a new connection of 20ms or a
+	// reused one, and totals spread from 30ms to 70ms.
+	var results []*result
+	for i := 0; i < 10; i++ {
+		conn := ms(0)
+		if i%2 == 0 {
+			conn = ms(20)
+		}
+		results = append(results, &result{statusCode: 200, duration: ms(float64(30 + 4*i)), connDuration: conn})
+	}
+	r := newTestReport(len(results))
+	r.histogramBuckets = 4
+	feed(r, results...)
+	if s := r.snapshot(); s.PhaseHistograms != nil {
+		t.Fatalf("PhaseHistograms = %v; want none unless asked for", s.PhaseHistograms)
+	}
+
+	r.phaseHistograms = true
+	s := r.snapshot()
+	conn := s.PhaseHistograms["conn"]
+	if len(conn) != 5 || conn[0].Mark != 0 || !approx(conn[4].Mark, 0.020) {
+		t.Fatalf("conn histogram = %+v; want 5 marks from 0 to 0.020", conn)
+	}
+	if conn[0].Count != 5 || conn[4].Count != 5 {
+		t.Errorf("conn histogram counts = %d, %d at the ends; want 5, 5", conn[0].Count, conn[4].Count)
+	}
+	if len(s.Histogram) != 5 || !approx(s.Histogram[0].Mark, 0.030) || !approx(s.Histogram[4].Mark, 0.066) {
+		t.Errorf("total histogram = %+v; want 5 marks from 0.030 to 0.066", s.Histogram)
+	}
+	if dns := s.PhaseHistograms["dns"]; len(dns) != 1 || dns[0].Count != 10 {
+		t.Errorf("dns histogram = %+v; want a single bucket of 10", dns)
+	}
+}
+
+func TestModeBucket(t *testing.T) {
+	// Buckets of 10ms from 10ms to 110ms, most latencies in (40, 50].
+	var results []*result
+	for _, v := range []float64{10, 25, 42, 44, 45, 47, 50, 61, 63, 88, 110} {
+		results = append(results, &result{statusCode: 200, duration: ms(v)})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	s := r.snapshot()
+	if s.ModeBucketIndex != 4 {
+		t.Errorf("ModeBucketIndex = %d; want 4", s.ModeBucketIndex)
+	}
+	if got := s.ModeBucketRange; !approx(got[0], 0.040) || !approx(got[1], 0.050) {
+		t.Errorf("ModeBucketRange = %v; want [0.040, 0.050]", got)
+	}
+
+	if i, rng := modeBucket(nil); i != 0 || rng != [2]float64{} {
+		t.Errorf("modeBucket(nil) = %d, %v; want 0, [0 0]", i, rng)
+	}
+}
+
+func TestBimodal(t *testing.T) {
+	// Cache hits at about 10ms and misses at about 100ms.
+	var results []*result
+	for i := 0; i < 100; i++ {
+		v := 10 + float64(i%3)
+		if i%5 < 2 {
+			v = 100 - float64(i%3)
+		}
+		results = append(results, &result{statusCode: 200, duration: ms(v)})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	s := r.snapshot()
+	if !s.Bimodal || len(s.ModalRanges) != 2 {
+		t.Fatalf("Bimodal, ModalRanges = %v, %v; want true and 2 ranges", s.Bimodal, s.ModalRanges)
+	}
+	if lo, hi := s.ModalRanges[0], s.ModalRanges[1]; lo[1] > 0.02 || hi[0] < 0.09 {
+		t.Errorf("ModalRanges = %v; want peaks at about 10ms and 100ms", s.ModalRanges)
+	}
+
+	// The sum of two uniform latencies has a single peak between 10ms
+	// and 100ms.
+	results = nil
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 100; j++ {
+			results = append(results, &result{statusCode: 200, duration: ms(10 + float64(i+j)*0.45)})
+		}
+	}
+	r = newTestReport(len(results))
+	feed(r, results...)
+	if s := r.snapshot(); s.Bimodal || s.ModalRanges != nil {
+		t.Errorf("unimodal: Bimodal, ModalRanges = %v, %v; want false, nil", s.Bimodal, s.ModalRanges)
+	}
+}
+
+func TestLogHistogram(t *testing.T) {
+	var data []float64
+	for i := 0; i < 90; i++ {
+		data = append(data, 0.005)
+	}
+	data = append(data, 0.01, 0.02, 0.05, 0.1, 0.5, 1, 5)
+
+	nonEmpty := func(buckets []Bucket) int {
+		var n int
+		for _, b := range buckets {
+			if b.Count > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	r := newTestReport(0)
+	r.lats = data
+	linear := nonEmpty(r.histogram(data))
+
+	r.logHistogram = true
+	buckets := r.histogram(data)
+	if got := nonEmpty(buckets); got <= linear || got < 6 {
+		t.Errorf("log histogram has %d non-empty buckets, linear has %d; want at least 6 and more than linear", got, linear)
+	}
+	if buckets[0].Mark != 0.005 || buckets[len(buckets)-1].Mark != 5 {
+		t.Errorf("marks span [%v, %v]; want [0.005, 5]", buckets[0].Mark, buckets[len(buckets)-1].Mark)
+	}
+
+	// A fastest latency of zero is clamped rather than producing NaN marks.
+	for _, b := range r.histogram(append([]float64{0}, data...)) {
+		if math.IsNaN(b.Mark) || math.IsInf(b.Mark, 0) {
+			t.Fatalf("invalid mark %v with a zero fastest latency", b.Mark)
+		}
+	}
+}
+
+func TestStatusLatencies(t *testing.T) {
+	r := newTestReport(6)
+	feed(r,
+		&result{statusCode: 500, duration: ms(1)},
+		&result{statusCode: 200, duration: ms(40)},
+		&result{statusCode: 500, duration: ms(3)},
+		&result{statusCode: 200, duration: ms(20)},
+		&result{statusCode: 200, duration: ms(30)},
+		&result{err: errors.New("timeout"), duration: ms(100)},
+	)
+	got := r.snapshot().StatusLatencies
+	want := map[int]StatusLatency{
+		200: {Count: 3, Average: 0.030, P95: 0.040},
+		500: {Count: 2, Average: 0.002, P95: 0.003},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d status codes; want %d: %v", len(got), len(want), got)
+	}
+	for code, w := range want {
+		g := got[code]
+		if g.Count != w.Count || !approx(g.Average, w.Average) || !approx(g.P95, w.P95) {
+			t.Errorf("[%d] got %+v; want %+v", code, g, w)
+		}
+	}
+}
+
+func TestSnapshotKeepsArrivalOrder(t *testing.T) {
+	r := newTestReport(4)
+	feed(r,
+		&result{statusCode: 200, duration: ms(40), offset: ms(1)},
+		&result{statusCode: 500, duration: ms(10), offset: ms(2)},
+		&result{statusCode: 404, duration: ms(30), offset: ms(3)},
+		&result{statusCode: 201, duration: ms(20), offset: ms(4)},
+	)
+	// Mid-run, the rate depends on the time of the snapshot.
+	r.aggregate(time.Second)
+	first := r.snapshot()
+	second := r.snapshot()
+
+	wantLats := []float64{0.040, 0.010, 0.030, 0.020}
+	wantCodes := []int{200, 500, 404, 201}
+	wantOffsets := []float64{0.001, 0.002, 0.003, 0.004}
+	for i := range wantLats {
+		if !approx(r.lats[i], wantLats[i]) || r.statusCodes[i] != wantCodes[i] || !approx(r.offsets[i], wantOffsets[i]) {
+			t.Errorf("sample %d = (%v, %v, %v); want (%v, %v, %v)", i,
+				r.lats[i], r.statusCodes[i], r.offsets[i], wantLats[i], wantCodes[i], wantOffsets[i])
+		}
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("repeated snapshots differ:\n%+v\n%+v", first, second)
+	}
+	if first.Fastest != 0.010 || first.Slowest != 0.040 {
+		t.Errorf("fastest, slowest = %v, %v; want 0.010, 0.040", first.Fastest, first.Slowest)
+	}
+}
+
+func TestThroughput(t *testing.T) {
+	// A 10 second run with a request started every 500ms. The requests
+	// of the second half of the run take twice as long.
+	var results []*result
+	for i := 0; i < 20; i++ {
+		d := ms(10)
+		if i >= 10 {
+			d = ms(20)
+		}
+		results = append(results, &result{statusCode: 200, duration: d, offset: time.Duration(i) * 500 * time.Millisecond})
+	}
+
+	r := newTestReport(len(results))
+	feed(r, results...)
+	points := r.snapshot().Throughput
+	if len(points) != 10 {
+		t.Fatalf("got %d points; want 10", len(points))
+	}
+	for i, p := range points {
+		want := 0.010
+		if i >= 5 {
+			want = 0.020
+		}
+		if p.Second != i || p.Count != 2 || !approx(p.AvgLatency, want) {
+			t.Errorf("point %d = %+v; want {Second:%d Count:2 AvgLatency:%v}", i, p, i, want)
+		}
+	}
+
+	r = newTestReport(len(results))
+	r.throughputWindow = 3
+	feed(r, results...)
+	points = r.snapshot().Throughput
+	wantCounts := []int{6, 6, 6, 2}
+	if len(points) != len(wantCounts) {
+		t.Fatalf("got %d points with a 3s window; want %d", len(points), len(wantCounts))
+	}
+	for i, p := range points {
+		if p.Second != i*3 || p.Count != wantCounts[i] {
+			t.Errorf("point %d = %+v; want Second %d, Count %d", i, p, i*3, wantCounts[i])
+		}
+	}
+}
+
+func TestThroughputBytes(t *testing.T) {
+	// Results arrive out of order, and one of unknown size.
+	r := newTestReport(5)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), offset: 2500 * time.Millisecond, contentLength: 300},
+		&result{statusCode: 200, duration: ms(10), offset: 100 * time.Millisecond, contentLength: 100},
+		&result{statusCode: 200, duration: ms(10), offset: 2 * time.Second, contentLength: -1},
+		&result{statusCode: 200, duration: ms(10), offset: 900 * time.Millisecond, contentLength: 50},
+		&result{statusCode: 200, duration: ms(10), offset: 2100 * time.Millisecond, contentLength: 1000},
+	)
+	r.finalize(3 * time.Second)
+	wantBytes := []int64{150, 0, 1300}
+	points := r.snapshot().Throughput
+	if len(points) != len(wantBytes) {
+		t.Fatalf("got %d points; want %d", len(points), len(wantBytes))
+	}
+	for i, p := range points {
+		if p.Bytes != wantBytes[i] {
+			t.Errorf("point %d has %d bytes; want %d", i, p.Bytes, wantBytes[i])
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	r := newTestReport(5)
+	progress := &bytes.Buffer{}
+	r.diagW = progress
+	r.progressInterval = 2
+	feed(r,
+		&result{statusCode: 200, duration: ms(1)},
+		&result{err: errors.New("timeout")},
+		&result{statusCode: 200, duration: ms(1)},
+		&result{err: errors.New("timeout")},
+		&result{statusCode: 200, duration: ms(1)},
+	)
+	updates := strings.Split(strings.TrimPrefix(progress.String(), "\r"), "\r")
+	if len(updates) != 2 {
+		t.Fatalf("got %d progress updates; want 2: %q", len(updates), progress)
+	}
+	if !strings.HasPrefix(updates[0], "2 requests done, ") || !strings.HasSuffix(updates[0], ", 1 errors") {
+		t.Errorf("unexpected first update %q", updates[0])
+	}
+	if !strings.HasPrefix(updates[1], "4 requests done, ") || !strings.HasSuffix(updates[1], ", 2 errors\n") {
+		t.Errorf("unexpected last update %q; want it terminated by a newline", updates[1])
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	newErrReport := func(max float64) *report {
+		r := newTestReport(4)
+		r.maxErrorRate = max
+		feed(r,
+			&result{statusCode: 200, duration: ms(1)},
+			&result{err: errors.New("timeout")},
+			&result{statusCode: 200, duration: ms(1)},
+			&result{statusCode: 200, duration: ms(1)},
+		)
+		return r
+	}
+
+	r := newErrReport(0)
+	if err := r.finalize(time.Second); err != nil {
+		t.Errorf("finalize without a maximum error rate = %v; want nil", err)
+	}
+	if got := r.snapshot().ErrorRate; got != 0.25 {
+		t.Errorf("ErrorRate = %v; want 0.25", got)
+	}
+	if err := newErrReport(0.25).finalize(time.Second); err != nil {
+		t.Errorf("finalize at the maximum error rate = %v; want nil", err)
+	}
+	if err := newErrReport(0.01).finalize(time.Second); err == nil {
+		t.Error("finalize above the maximum error rate = nil; want an error")
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxRate float64
+		slo     string
+		failed  string
+	}{
+		{"passing", 0.5, "p99<1s", ""},
+		{"error rate", 0.1, "p99<1s", "error rate 25.00% exceeds"},
+		{"SLO", 0.5, "p99<5ms", "SLO failed: p99"},
+	}
+	for _, tt := range tests {
+		r := newTestReport(4)
+		buf := &bytes.Buffer{}
+		r.w = buf
+		r.quiet = true
+		r.maxErrorRate = tt.maxRate
+		r.slo, _ = ParseSLO(tt.slo)
+		feed(r,
+			&result{statusCode: 200, duration: ms(1)},
+			&result{err: errors.New("timeout")},
+			&result{statusCode: 200, duration: ms(10)},
+			&result{statusCode: 200, duration: ms(1)},
+		)
+		err := r.finalize(time.Second)
+		if tt.failed == "" {
+			if err != nil || buf.Len() > 0 {
+				t.Errorf("%s: finalize = %v with %d bytes of output; want nil and no output", tt.name, err, buf.Len())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.failed) {
+			t.Errorf("%s: finalize = %v; want an error with %q", tt.name, err, tt.failed)
+		}
+		if !strings.Contains(buf.String(), "Summary:") || !strings.Contains(buf.String(), "Latency distribution") {
+			t.Errorf("%s: got output\n%s\nwant the full report", tt.name, buf)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: err}
+	}
+	dial := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: err}
+	}
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{urlErr(dial(&net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true})), ErrCategoryDNS},
+		{urlErr(dial(&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true})), ErrCategoryDNS},
+		{urlErr(dial(os.NewSyscallError("connect", syscall.ECONNREFUSED))), ErrCategoryRefused},
+		{urlErr(dial(timeoutError{})), ErrCategoryTimeout},
+		{urlErr(context.DeadlineExceeded), ErrCategoryTimeout},
+		{urlErr(x509.UnknownAuthorityError{}), ErrCategoryTLS},
+		{urlErr(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), ErrCategoryTLS},
+		{urlErr(io.EOF), ErrCategoryEOF},
+		{urlErr(fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF)), ErrCategoryEOF},
+		{errors.New("boom"), ErrCategoryOther},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %q; want %q", tt.err, got, tt.want)
+		}
+	}
+
+	r := newTestReport(3)
+	refused := func(addr string) error {
+		return urlErr(&net.OpError{Op: "dial", Net: "tcp", Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 80},
+			Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)})
+	}
+	feed(r,
+		&result{err: refused("10.0.0.1")},
+		&result{err: refused("10.0.0.2")},
+		&result{statusCode: 200, duration: ms(1)},
+	)
+	s := r.snapshot()
+	if len(s.ErrorDist) != 2 {
+		t.Errorf("got %d raw errors; want 2", len(s.ErrorDist))
+	}
+	if got := s.ErrorCategoryDist; len(got) != 1 || got[ErrCategoryRefused] != 2 {
+		t.Errorf("ErrorCategoryDist = %v; want 2 refused connections", got)
+	}
+}
+
+func TestReservoirSampling(t *testing.T) {
+	const n, limit = 100000, 1000
+	run := func(reservoir bool) *report {
+		r := newTestReport(0)
+		r.results = make(chan *result, n)
+		r.maxSamples = limit
+		r.reservoir = reservoir
+		r.rng = rand.New(rand.NewSource(1))
+		results := make([]*result, n)
+		for i := range results {
+			d := time.Duration(i) * time.Millisecond
+			results[i] = &result{statusCode: 200, duration: d, offset: d}
+		}
+		feed(r, results...)
+		return r
+	}
+	mean := func(data []float64) float64 {
+		var sum float64
+		for _, v := range data {
+			sum += v
+		}
+		return sum / float64(len(data))
+	}
+
+	// By default, only the first results are retained.
+	r := run(false)
+	if len(r.lats) != limit || r.lats[limit-1] != float64(limit-1)/1000 {
+		t.Fatalf("truncation retained %d samples up to %v; want the first %d", len(r.lats), r.lats[len(r.lats)-1], limit)
+	}
+
+	// The durations are uniform in [0, n) ms, so a uniform sample has a
+	// mean of about n/2 ms and half of its samples in the second half.
+	r = run(true)
+	if len(r.lats) != limit {
+		t.Fatalf("reservoir retained %d samples; want %d", len(r.lats), limit)
+	}
+	want := float64(n-1) / 2 / 1000
+	if got := mean(r.lats); math.Abs(got-want) > 0.05*want {
+		t.Errorf("sample mean = %v; want %v within 5%%", got, want)
+	}
+	var late int
+	for _, v := range r.lats {
+		if v >= want {
+			late++
+		}
+	}
+	if frac := float64(late) / limit; math.Abs(frac-0.5) > 0.05 {
+		t.Errorf("%v of the samples are from the second half of the run; want 0.5 within 0.05", frac)
+	}
+	for i, v := range r.lats {
+		if r.offsets[i] != v {
+			t.Fatalf("sample %d has latency %v but offset %v; want them aligned", i, v, r.offsets[i])
+		}
+	}
+}
+
+func TestSizeMinMax(t *testing.T) {
+	r := newTestReport(5)
+	feed(r,
+		&result{statusCode: 200, duration: ms(1), contentLength: 300},
+		&result{statusCode: 200, duration: ms(1), contentLength: 0},
+		&result{statusCode: 200, duration: ms(1), contentLength: 100},
+		&result{statusCode: 200, duration: ms(1), contentLength: -1},
+		&result{statusCode: 200, duration: ms(1), contentLength: 2000},
+	)
+	s := r.snapshot()
+	if s.SizeMin != 100 || s.SizeMax != 2000 || s.SizeTotal != 2400 {
+		t.Errorf("SizeMin, SizeMax, SizeTotal = %d, %d, %d; want 100, 2000, 2400", s.SizeMin, s.SizeMax, s.SizeTotal)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	// A 10 second run with a request started every 100ms, the requests
+	// of the first 3 seconds taking longer.
+	var results []*result
+	for i := 0; i < 100; i++ {
+		d := ms(10)
+		if i < 30 {
+			d = ms(100)
+		}
+		results = append(results, &result{statusCode: 200, duration: d, offset: time.Duration(i) * 100 * time.Millisecond})
+	}
+	r := newTestReport(len(results))
+	r.warmup = 3
+	feed(r, results...)
+	r.finalize(10 * time.Second)
+	s := r.snapshot()
+	if s.NumRes != 70 || len(s.Lats) != 70 || s.NumWarmup != 30 {
+		t.Errorf("got %d results, %d samples, %d warmup; want 70, 70, 30", s.NumRes, len(s.Lats), s.NumWarmup)
+	}
+	if !approx(s.Rps, 10) {
+		t.Errorf("Rps = %v; want 10", s.Rps)
+	}
+	if !approx(s.Average, 0.010) || !approx(s.Slowest, 0.010) {
+		t.Errorf("Average, Slowest = %v, %v; want 0.010, 0.010", s.Average, s.Slowest)
+	}
+
+	// A run stopped during the warmup has no rate rather than a negative one.
+	r = newTestReport(len(results))
+	r.warmup = 3
+	feed(r, results[:20]...)
+	r.finalize(2 * time.Second)
+	if s := r.snapshot(); s.Rps != 0 {
+		t.Errorf("Rps of a run within the warmup = %v; want 0", s.Rps)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := newTestReport(3)
+	feed(a,
+		&result{statusCode: 200, duration: ms(10), contentLength: 100},
+		&result{statusCode: 500, duration: ms(20), contentLength: 50},
+		&result{err: errors.New("boom")},
+	)
+	b := newTestReport(3)
+	feed(b,
+		&result{statusCode: 200, duration: ms(60), contentLength: 300},
+		&result{err: errors.New("boom")},
+		&result{err: syscall.ECONNREFUSED},
+	)
+	a.merge(b)
+	a.finalize(time.Second)
+	s := a.snapshot()
+
+	if s.NumRes != 6 || len(s.Lats) != 3 {
+		t.Errorf("got %d results, %d samples; want 6, 3", s.NumRes, len(s.Lats))
+	}
+	if !approx(s.ErrorRate, 0.5) {
+		t.Errorf("ErrorRate = %v; want 0.5", s.ErrorRate)
+	}
+	// The average of the merged samples, not the average of the averages.
+	if !approx(s.Average, 0.030) {
+		t.Errorf("Average = %v; want 0.030", s.Average)
+	}
+	if !approx(s.Fastest, 0.010) || !approx(s.Slowest, 0.060) {
+		t.Errorf("Fastest, Slowest = %v, %v; want 0.010, 0.060", s.Fastest, s.Slowest)
+	}
+	if s.SizeTotal != 450 || s.SizeMin != 50 || s.SizeMax != 300 {
+		t.Errorf("SizeTotal, SizeMin, SizeMax = %d, %d, %d; want 450, 50, 300", s.SizeTotal, s.SizeMin, s.SizeMax)
+	}
+	if want := map[int]int{200: 2, 500: 1}; !reflect.DeepEqual(s.StatusCodeDist, want) {
+		t.Errorf("StatusCodeDist = %v; want %v", s.StatusCodeDist, want)
+	}
+	if want := map[string]int{"boom": 2, syscall.ECONNREFUSED.Error(): 1}; !reflect.DeepEqual(s.ErrorDist, want) {
+		t.Errorf("ErrorDist = %v; want %v", s.ErrorDist, want)
+	}
+	if want := map[string]int{ErrCategoryOther: 2, ErrCategoryRefused: 1}; !reflect.DeepEqual(s.ErrorCategoryDist, want) {
+		t.Errorf("ErrorCategoryDist = %v; want %v", s.ErrorCategoryDist, want)
+	}
+}
+
+func TestMergeMaxSamples(t *testing.T) {
+	a := newTestReport(2)
+	a.maxSamples = 3
+	feed(a, &result{duration: ms(1)}, &result{duration: ms(2)})
+	b := newTestReport(2)
+	feed(b, &result{duration: ms(3)}, &result{duration: ms(4)})
+	a.merge(b)
+	if want := []float64{0.001, 0.002, 0.003}; !reflect.DeepEqual(a.lats, want) {
+		t.Errorf("lats = %v; want %v", a.lats, want)
+	}
+	if a.numRes != 4 {
+		t.Errorf("numRes = %d; want 4", a.numRes)
+	}
+}
+
+func TestDiagnosticsWriter(t *testing.T) {
+	results := []*result{
+		{statusCode: 200, duration: ms(10)},
+		{statusCode: 200, duration: ms(20)},
+	}
+
+	// A failing template reports the error to the diagnostics only.
+	r := newTestReport(2)
+	out, diag := &bytes.Buffer{}, &bytes.Buffer{}
+	r.w, r.diagW = out, diag
+	r.output = "{{ .NoSuchField }}"
+	feed(r, results...)
+	r.finalize(time.Second)
+	if out.Len() != 0 {
+		t.Errorf("report output = %q; want none", out)
+	}
+	if !strings.HasPrefix(diag.String(), "error: ") {
+		t.Errorf("diagnostics = %q; want the template error", diag)
+	}
+
+	// The progress goes to the diagnostics, the JSON report to the output.
+	r = newTestReport(2)
+	out, diag = &bytes.Buffer{}, &bytes.Buffer{}
+	r.w, r.diagW = out, diag
+	r.output = "json"
+	r.progressInterval = 1
+	feed(r, results...)
+	r.finalize(time.Second)
+	var rep Report
+	if err := json.Unmarshal(out.Bytes(), &rep); err != nil {
+		t.Errorf("report output is not a JSON report: %v", err)
+	}
+	if !strings.Contains(diag.String(), "2 requests done, ") {
+		t.Errorf("diagnostics = %q; want the progress", diag)
+	}
+}
+
+func TestConnReuse(t *testing.T) {
+	r := newTestReport(6)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), connDuration: ms(3)},
+		&result{statusCode: 200, duration: ms(10), connDuration: ms(4)},
+		&result{statusCode: 200, duration: ms(5), connReused: true},
+		&result{statusCode: 200, duration: ms(5), connReused: true},
+		&result{statusCode: 200, duration: ms(5), connReused: true},
+		&result{err: errors.New("timeout")},
+	)
+	s := r.snapshot()
+	if s.ConnNew != 2 || s.ConnReused != 3 {
+		t.Errorf("ConnNew, ConnReused = %d, %d; want 2, 3", s.ConnNew, s.ConnReused)
+	}
+}
+
+func TestSlowestN(t *testing.T) {
+	latencies := []float64{12, 80, 5, 80, 33, 700, 41, 9, 150, 60}
+	var results []*result
+	for i, v := range latencies {
+		code := 200
+		if v == 700 {
+			code = 504
+		}
+		results = append(results, &result{statusCode: code, duration: ms(v), offset: time.Duration(i) * time.Second})
+	}
+	r := newTestReport(len(results))
+	r.slowestN = 4
+	feed(r, results...)
+	s := r.snapshot()
+	want := []SlowRequest{
+		{Latency: 0.700, Offset: 5, StatusCode: 504},
+		{Latency: 0.150, Offset: 8, StatusCode: 200},
+		{Latency: 0.080, Offset: 1, StatusCode: 200},
+		{Latency: 0.080, Offset: 3, StatusCode: 200},
+	}
+	if len(s.SlowestN) != len(want) {
+		t.Fatalf("got %d slowest requests; want %d", len(s.SlowestN), len(want))
+	}
+	for i, got := range s.SlowestN {
+		if !approx(got.Latency, want[i].Latency) || got.Offset != want[i].Offset || got.StatusCode != want[i].StatusCode {
+			t.Errorf("slowest %d = %+v; want %+v", i, got, want[i])
+		}
+	}
+
+	r = newTestReport(len(results))
+	feed(r, results[:3]...)
+	if got := r.snapshot().SlowestN; len(got) != 3 {
+		t.Errorf("got %d slowest of 3 requests; want 3", len(got))
+	}
+}
+
+func TestReservoirSamplingSeed(t *testing.T) {
+	const n, limit = 10000, 100
+	run := func(seed int64) []float64 {
+		r := newTestReport(n)
+		r.maxSamples = limit
+		r.reservoir = true
+		r.rng = rand.New(rand.NewSource(seed))
+		results := make([]*result, n)
+		for i := range results {
+			results[i] = &result{statusCode: 200, duration: time.Duration(i) * time.Millisecond}
+		}
+		feed(r, results...)
+		return r.lats
+	}
+	if a, b := run(42), run(42); !reflect.DeepEqual(a, b) {
+		t.Errorf("samples of runs with the same seed differ")
+	}
+	if a, b := run(42), run(43); reflect.DeepEqual(a, b) {
+		t.Errorf("samples of runs with different seeds are identical")
+	}
+}
+
+func TestTTFB(t *testing.T) {
+	// The DNS lookup and the TLS handshake are part of the time to get
+	// the connection, the TTFB is everything before reading the response.
+	phases := func(conn, req, delay, res float64) *result {
+		return &result{
+			statusCode:    200,
+			duration:      ms(conn + req + delay + res),
+			connDuration:  ms(conn),
+			dnsDuration:   ms(conn / 2),
+			tlsDuration:   ms(conn / 4),
+			reqDuration:   ms(req),
+			delayDuration: ms(delay),
+			resDuration:   ms(res),
+		}
+	}
+	r := newTestReport(4)
+	r.percentiles = []float64{50, 100}
+	feed(r,
+		phases(8, 1, 11, 5),  // 20ms
+		phases(0, 1, 39, 10), // 40ms
+		phases(0, 2, 8, 1),   // 10ms
+		phases(4, 1, 25, 2),  // 30ms
+	)
+	r.finalize(time.Second)
+	s := r.snapshot()
+	if !approx(s.AvgTTFB, 0.025) || !approx(s.TtfbMin, 0.010) || !approx(s.TtfbMax, 0.040) {
+		t.Errorf("AvgTTFB, TtfbMin, TtfbMax = %v, %v, %v; want 0.025, 0.010, 0.040", s.AvgTTFB, s.TtfbMin, s.TtfbMax)
+	}
+	if got := s.LatencyDistribution; !approx(got[0].TtfbLatency, 0.020) || !approx(got[1].TtfbLatency, 0.040) {
+		t.Errorf("TTFB p50, p100 = %v, %v; want 0.020, 0.040", got[0].TtfbLatency, got[1].TtfbLatency)
+	}
+}
+
+func TestFileReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports", "run.json")
+	r, err := newFileReport(path, false, make(chan *result, 1), "json", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed(r, &result{statusCode: 200, duration: ms(10)})
+	if err := r.finalize(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep Report
+	if err := json.Unmarshal(data, &rep); err != nil || rep.NumRes != 1 {
+		t.Errorf("file holds %q; want the JSON report of 1 request", data)
+	}
+	if err := r.closer.Close(); err == nil {
+		t.Errorf("file is still open after finalize")
+	}
+}
+
+func TestFileReportGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.csv.gz")
+	r, err := newFileReport(path, false, make(chan *result, 2), "csv", 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed(r,
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 404, duration: ms(20)},
+	)
+	if err := r.finalize(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 || lines[0]+"\n" != csvHeader || !strings.Contains(lines[2], ",404,") {
+		t.Errorf("decompressed output = %q; want the header and 2 rows", data)
+	}
+}
+
+// errWriter fails every write.
+type errWriter struct{ err error }
+
+func (w errWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestFinalizeErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "run.json.gz")
+	r, err := newFileReport(path, false, make(chan *result, 1), "json", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.structuredSummary = "logfmt"
+	r.diagW = errWriter{errors.New("stderr is gone")}
+	r.reportURL = server.URL
+	r.slo, _ = ParseSLO("p99<1ms")
+	feed(r, &result{statusCode: 200, duration: ms(10)})
+	err = r.finalize(time.Second)
+	// Every failure is reported, and the failing sinks do not leave the
+	// output file incomplete.
+	for _, want := range []string{"SLO failed", "stderr is gone", http.StatusText(http.StatusBadRequest)} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("finalize = %v; want an error with %q", err, want)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep Report
+	if err := json.NewDecoder(zr).Decode(&rep); err != nil || rep.NumRes != 1 {
+		t.Errorf("decompressed report has %d results, %v; want 1", rep.NumRes, err)
+	}
+}
+
+func TestFileReportError(t *testing.T) {
+	dir := t.TempDir()
+	// The parent directory cannot be created over a file.
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFileReport(filepath.Join(file, "run.json"), false, nil, "", 0, 0); err == nil {
+		t.Errorf("creating a report under a file succeeded")
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	_, err := newFileReport(filepath.Join(readOnly, "run.json"), false, nil, "", 0, 0)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("got error %v; want a permission error", err)
+	}
+}
+
+func TestApdex(t *testing.T) {
+	// With T = 100ms: 4 satisfied, 3 tolerating (at most 400ms) and
+	// 3 frustrated, so (4 + 3/2) / 10 = 0.55.
+	latencies := []float64{20, 50, 100, 100, 101, 250, 400, 401, 900, 2000}
+	var results []*result
+	for _, v := range latencies {
+		results = append(results, &result{statusCode: 200, duration: ms(v)})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	if s := r.snapshot(); s.Apdex != 0 || s.ApdexT != 0 {
+		t.Errorf("Apdex, ApdexT = %v, %v without a threshold; want 0, 0", s.Apdex, s.ApdexT)
+	}
+	r.apdexT = 0.1
+	if s := r.snapshot(); !approx(s.Apdex, 0.55) || s.ApdexT != 0.1 {
+		t.Errorf("Apdex, ApdexT = %v, %v; want 0.55, 0.1", s.Apdex, s.ApdexT)
+	}
+}
+
+func TestMaxSamples(t *testing.T) {
+	r := newReport(io.Discard, make(chan *result, 8), "", 8, 5)
+	if got := cap(r.lats); got != 5 {
+		t.Errorf("samples capacity = %d; want 5", got)
+	}
+	var results []*result
+	for i := 0; i < 8; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(float64(i + 1))})
+	}
+	feed(r, results...)
+	r.finalize(time.Second)
+	s := r.snapshot()
+	if s.NumRes != 8 || len(s.Lats) != 5 || len(s.StatusCodes) != 5 {
+		t.Errorf("got %d results, %d samples, %d status codes; want 8, 5, 5", s.NumRes, len(s.Lats), len(s.StatusCodes))
+	}
+	if !approx(s.Slowest, 0.005) {
+		t.Errorf("Slowest = %v; want the slowest of the first 5, 0.005", s.Slowest)
+	}
+}
+
+func TestMaxSamplesAverages(t *testing.T) {
+	r := newReport(io.Discard, make(chan *result, 100), "", 100, 5)
+	var results []*result
+	for i := 0; i < 100; i++ {
+		results = append(results, &result{statusCode: 200, duration: time.Second, connDuration: ms(10), contentLength: 100})
+	}
+	feed(r, results...)
+	r.finalize(10 * time.Second)
+	s := r.snapshot()
+	if !approx(s.Average, 1) || !approx(s.AvgConn, 0.01) {
+		t.Errorf("Average, AvgConn = %v, %v; want 1, 0.01", s.Average, s.AvgConn)
+	}
+	if s.SizeReq != 100 {
+		t.Errorf("SizeReq = %d; want 100", s.SizeReq)
+	}
+}
+
+func TestMaxSamplesCSVRows(t *testing.T) {
+	r := newReport(io.Discard, make(chan *result, 8), "csv", 8, 5)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	var results []*result
+	for i := 0; i < 8; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(1)})
+	}
+	feed(r, results...)
+	r.finalize(time.Second)
+	// The header and a row for each of the retained samples.
+	if got := strings.Count(buf.String(), "\n"); got != 6 {
+		t.Errorf("got %d lines of CSV; want 6:\n%s", got, buf)
+	}
+}
+
+func TestDroppedCount(t *testing.T) {
+	r := newReport(io.Discard, make(chan *result, 10), "", 10, 4)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	var results []*result
+	for i := 0; i < 9; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(1)})
+	}
+	results = append(results, &result{err: errors.New("timeout")})
+	feed(r, results...)
+	r.finalize(time.Second)
+	s := r.snapshot()
+	if s.SampledCount != 4 || s.DroppedCount != 5 {
+		t.Errorf("SampledCount, DroppedCount = %d, %d; want 4, 5", s.SampledCount, s.DroppedCount)
+	}
+	if want := "Sampled:\t4 responses, the latencies of 5 more are not part of the statistics"; !strings.Contains(buf.String(), want) {
+		t.Errorf("summary does not note the dropped samples:\n%s", buf)
+	}
+
+	r = newTestReport(2)
+	feed(r, results[:2]...)
+	if s := r.snapshot(); s.SampledCount != 2 || s.DroppedCount != 0 {
+		t.Errorf("SampledCount, DroppedCount = %d, %d; want 2, 0", s.SampledCount, s.DroppedCount)
+	}
+}
+
+func TestArrivalCV(t *testing.T) {
+	newResults := func(offsets ...float64) []*result {
+		var results []*result
+		for _, v := range offsets {
+			results = append(results, &result{statusCode: 200, duration: ms(1), offset: ms(v)})
+		}
+		return results
+	}
+
+	// Evenly paced, in any order of arrival.
+	r := newTestReport(6)
+	feed(r, newResults(0, 100, 200, 400, 300, 500)...)
+	if got := r.snapshot().ArrivalCV; !approx(got, 0) {
+		t.Errorf("ArrivalCV of evenly paced requests = %v; want 0", got)
+	}
+
+	// Bursts of three requests, a second apart.
+	r = newTestReport(9)
+	feed(r, newResults(0, 1, 2, 1000, 1001, 1002, 2000, 2001, 2002)...)
+	if got := r.snapshot().ArrivalCV; got < 1 {
+		t.Errorf("ArrivalCV of bursty requests = %v; want above 1", got)
+	}
+
+	if got := arrivalCV([]float64{0, 1}); got != 0 {
+		t.Errorf("arrivalCV of 2 offsets = %v; want 0", got)
+	}
+}
+
+func TestStatusCodeCounts(t *testing.T) {
+	var results []*result
+	for _, code := range []int{503, 200, 0, 404, 200, 301, 200} {
+		results = append(results, &result{statusCode: code, duration: ms(1)})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	want := []StatusCodeCount{
+		{Code: 0, Count: 1, Class: "error"},
+		{Code: 200, Count: 3, Class: "2xx"},
+		{Code: 301, Count: 1, Class: "3xx"},
+		{Code: 404, Count: 1, Class: "4xx"},
+		{Code: 503, Count: 1, Class: "5xx"},
+	}
+	if got := r.snapshot().StatusCodeCounts; !reflect.DeepEqual(got, want) {
+		t.Errorf("StatusCodeCounts = %+v; want %+v", got, want)
+	}
+}
+
+func TestCDF(t *testing.T) {
+	var results []*result
+	for i := 1000; i > 0; i-- {
+		results = append(results, &result{statusCode: 200, duration: ms(float64(i))})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	cdf := r.snapshot().CDF
+	if len(cdf) != defaultCDFPoints {
+		t.Fatalf("got %d points; want %d", len(cdf), defaultCDFPoints)
+	}
+	for i, p := range cdf {
+		want := float64(i+1) / defaultCDFPoints
+		if !approx(p.CumulativeFraction, want) || !approx(p.Latency, want) {
+			t.Errorf("point %d = %+v; want %v of the latencies up to %v", i, p, want, want)
+		}
+	}
+	if last := cdf[len(cdf)-1]; last.CumulativeFraction != 1 || !approx(last.Latency, 1) {
+		t.Errorf("last point = %+v; want all the latencies up to the slowest", last)
+	}
+
+	// There are no more points than samples.
+	r.cdfPoints = 7
+	if got := r.cdf([]float64{1, 2, 3}); len(got) != 3 || got[2].CumulativeFraction != 1 {
+		t.Errorf("cdf of 3 samples = %+v; want 3 points up to 1", got)
+	}
+	if got := r.cdf([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}); len(got) != 7 || got[6].CumulativeFraction != 1 || got[6].Latency != 10 {
+		t.Errorf("cdf of 10 samples = %+v; want 7 points up to 1", got)
+	}
+}
+
+func TestReqSizeTotal(t *testing.T) {
+	r := newTestReport(4)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), reqSize: 300, contentLength: 1000},
+		&result{statusCode: 200, duration: ms(10), reqSize: 200, contentLength: 500},
+		&result{statusCode: 200, duration: ms(10), contentLength: 1500},
+		&result{err: errors.New("timeout"), reqSize: 1000},
+	)
+	r.finalize(2 * time.Second)
+	s := r.snapshot()
+	if s.ReqSizeTotal != 500 || s.SizeTotal != 3000 {
+		t.Errorf("ReqSizeTotal, SizeTotal = %d, %d; want 500, 3000", s.ReqSizeTotal, s.SizeTotal)
+	}
+	// 3500 bytes in 2 seconds.
+	if !approx(s.MBPerSec, 0.00175) {
+		t.Errorf("MBPerSec = %v; want 0.00175", s.MBPerSec)
+	}
+}
+
+func TestWeightedPercentiles(t *testing.T) {
+	newResults := func(weights ...int) []*result {
+		var results []*result
+		for i, w := range weights {
+			results = append(results, &result{statusCode: 200, duration: ms(float64(10 * (i + 1))), weight: w})
+		}
+		return results
+	}
+
+	// 10ms to 50ms with weights 1, 1, 1, 1 and 6: 6 of the 10 requests
+	// took 50ms, so the weighted p50 is 50ms and the average
+	// (10+20+30+40+6*50)/10 = 40ms.
+	r := newTestReport(5)
+	r.percentiles = []float64{40, 50}
+	feed(r, newResults(1, 0, 1, 1, 6)...)
+	r.finalize(time.Second)
+	s := r.snapshot()
+	if !approx(s.Average, 0.040) || !approx(s.Median, 0.050) {
+		t.Errorf("Average, Median = %v, %v; want 0.040, 0.050", s.Average, s.Median)
+	}
+	if p40, p50 := s.LatencyDistribution[0].Latency, s.LatencyDistribution[1].Latency; !approx(p40, 0.040) || !approx(p50, 0.050) {
+		t.Errorf("p40, p50 = %v, %v; want 0.040, 0.050", p40, p50)
+	}
+
+	// Without weights, the statistics are unweighted.
+	for _, weights := range [][]int{{0, 0, 0, 0, 0}, {1, 1, 1, 1, 1}} {
+		r := newTestReport(5)
+		r.percentiles = []float64{40, 50}
+		feed(r, newResults(weights...)...)
+		r.finalize(time.Second)
+		s := r.snapshot()
+		if r.weighted || !approx(s.Average, 0.030) || !approx(s.Median, 0.030) || !approx(s.LatencyDistribution[0].Latency, 0.020) {
+			t.Errorf("weights %v: Average, Median, p40 = %v, %v, %v; want 0.030, 0.030, 0.020", weights, s.Average, s.Median, s.LatencyDistribution[0].Latency)
+		}
+	}
+}
+
+func TestWeightedTrim(t *testing.T) {
+	// 10ms to 50ms with weights 1, 1, 1, 1 and 6, of a weighted average
+	// of 40ms.
+	var results []*result
+	for i, w := range []int{1, 1, 1, 1, 6} {
+		results = append(results, &result{statusCode: 200, duration: ms(float64(10 * (i + 1))), weight: w})
+	}
+	r := newTestReport(5)
+	feed(r, results...)
+	r.finalize(time.Second)
+	// The weighted variance is (30² + 20² + 10² + 0 + 6*10²) / 10 = 200.
+	if s := r.snapshot(); !approx(s.Stddev, math.Sqrt(200)/1000) {
+		t.Errorf("Stddev = %v; want %v", s.Stddev, math.Sqrt(200)/1000)
+	}
+
+	// Trimming 10% of the total weight of 10 leaves out the 10ms request
+	// and one of the weight of the 50ms one: (20+30+40+5*50)/8 = 42.5ms.
+	r = newTestReport(5)
+	r.trimPercent = 10
+	feed(r, results...)
+	r.finalize(time.Second)
+	if s := r.snapshot(); !approx(s.Average, 0.0425) {
+		t.Errorf("trimmed Average = %v; want 0.0425", s.Average)
+	}
+
+	w := trimWeighted(weightedSamples{values: []float64{1, 2, 3}, weights: []int{2, 1, 3}}, 40)
+	if want := []int{0, 1, 1}; !reflect.DeepEqual(w.weights, want) {
+		t.Errorf("trimmed weights = %v; want %v", w.weights, want)
+	}
+}
+
+func TestCountTimeoutsAsLatency(t *testing.T) {
+	results := func() []*result {
+		return []*result{
+			{statusCode: 200, duration: ms(20)},
+			{statusCode: 200, duration: ms(30)},
+			{err: &url.Error{Op: "Get", URL: "http://example.com", Err: timeoutError{}}, duration: ms(1050)},
+			{err: errors.New("boom"), duration: ms(500)},
+		}
+	}
+	for _, count := range []bool{false, true} {
+		r := newTestReport(4)
+		r.countTimeoutsAsLatency = count
+		r.timeout = time.Second
+		feed(r, results()...)
+		r.finalize(time.Second)
+		s := r.snapshot()
+		if s.NumErrs != 2 {
+			t.Errorf("count %v: NumErrs = %d; want 2", count, s.NumErrs)
+		}
+		// The timeout counts with its duration capped at the timeout.
+		wantLats, wantSlowest, wantAverage := 2, 0.030, 0.025
+		if count {
+			wantLats, wantSlowest, wantAverage = 3, 1.0, 0.350
+		}
+		if len(s.Lats) != wantLats || !approx(s.Slowest, wantSlowest) || !approx(s.Average, wantAverage) {
+			t.Errorf("count %v: len(Lats), Slowest, Average = %d, %v, %v; want %d, %v, %v",
+				count, len(s.Lats), s.Slowest, s.Average, wantLats, wantSlowest, wantAverage)
+		}
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	r := newTestReport(5)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(10), attempts: 1},
+		&result{statusCode: 200, duration: ms(30), attempts: 3},
+		&result{statusCode: 200, duration: ms(20), attempts: 2},
+		&result{err: errors.New("boom"), attempts: 4},
+	)
+	s := r.snapshot()
+	if s.TotalAttempts != 11 || s.RetriedRequests != 3 {
+		t.Errorf("TotalAttempts, RetriedRequests = %d, %d; want 11, 3", s.TotalAttempts, s.RetriedRequests)
+	}
+}
+
+func TestTailRatio(t *testing.T) {
+	var results []*result
+	for i := 1; i <= 100; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(float64(i))})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	s := r.snapshot()
+	if !approx(s.P99P50Ratio, 1.98) || !approx(s.IQR, 0.050) {
+		t.Errorf("P99P50Ratio, IQR = %v, %v; want 1.98, 0.050", s.P99P50Ratio, s.IQR)
+	}
+
+	// A zero median does not divide by zero.
+	r = newTestReport(3)
+	feed(r,
+		&result{statusCode: 200},
+		&result{statusCode: 200},
+		&result{statusCode: 200, duration: ms(10)},
+	)
+	if s := r.snapshot(); s.P99P50Ratio != 0 {
+		t.Errorf("P99P50Ratio with a zero median = %v; want 0", s.P99P50Ratio)
+	}
+}
+
+func TestAveragesWithoutSamples(t *testing.T) {
+	// A plain HTTP run has no TLS handshakes.
+	r := newTestReport(2)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), connDuration: ms(2)},
+		&result{statusCode: 200, duration: ms(20), connDuration: ms(4)},
+	)
+	r.finalize(time.Second)
+	if s := r.snapshot(); s.AvgTLS != 0 || !approx(s.AvgConn, 0.003) {
+		t.Errorf("AvgTLS, AvgConn = %v, %v; want 0, 0.003", s.AvgTLS, s.AvgConn)
+	}
+
+	// Without any sample, the averages are zero and the report encodes.
+	r = newTestReport(1)
+	feed(r, &result{err: errors.New("boom")})
+	r.finalize(time.Second)
+	if _, err := json.Marshal(r.snapshot()); err != nil {
+		t.Errorf("encoding a report without samples: %v", err)
+	}
+}
+
+func TestErrorsPerSecond(t *testing.T) {
+	boom := errors.New("connection refused")
+	r := newTestReport(7)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), offset: ms(100)},
+		&result{err: boom, offset: ms(200)},
+		&result{err: boom, offset: ms(2100)},
+		&result{err: boom, offset: ms(2500)},
+		&result{statusCode: 200, duration: ms(10), offset: ms(2600)},
+		&result{err: boom, offset: ms(2999)},
+		&result{err: boom, offset: ms(4000)},
+	)
+	want := []int{1, 0, 3, 0, 1}
+	if got := r.snapshot().ErrorsPerSecond; !reflect.DeepEqual(got, want) {
+		t.Errorf("ErrorsPerSecond = %v; want %v", got, want)
+	}
+}
+
+func TestSortedLats(t *testing.T) {
+	r := newTestReport(5)
+	feed(r,
+		&result{statusCode: 200, duration: ms(30)},
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(50)},
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(20)},
+	)
+	s := r.snapshot()
+	if !sort.Float64sAreSorted(s.SortedLats) || len(s.SortedLats) != len(s.Lats) {
+		t.Errorf("SortedLats = %v; want the %d latencies sorted", s.SortedLats, len(s.Lats))
+	}
+	if want := []float64{0.030, 0.010, 0.050, 0.010, 0.020}; !reflect.DeepEqual(s.Lats, want) {
+		t.Errorf("Lats = %v; want %v in arrival order", s.Lats, want)
+	}
+	// The samples of the report stay in arrival order too.
+	if r.lats[0] != 0.030 {
+		t.Errorf("snapshot sorted the samples of the report")
+	}
+}
+
+func TestTrimPercent(t *testing.T) {
+	// A single stall of 30 seconds among requests of 10 to 50ms.
+	var results []*result
+	for i := 0; i < 99; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(float64(10 + 10*(i%5)))})
+	}
+	results = append(results, &result{statusCode: 200, duration: ms(30000)})
+
+	raw := newTestReport(len(results))
+	feed(raw, results...)
+	raw.finalize(time.Second)
+	r := newTestReport(len(results))
+	r.trimPercent = 1
+	feed(r, results...)
+	r.finalize(time.Second)
+
+	rawSnap, s := raw.snapshot(), r.snapshot()
+	if rawSnap.Average < 0.3 {
+		t.Fatalf("raw Average = %v; want the stall to skew it", rawSnap.Average)
+	}
+	// The 99 requests add up to 2.950 seconds. Trimming drops the stall
+	// and one of the fastest requests.
+	if want := (2.950 - 0.010) / 98; !approx(s.Average, want) || s.Stddev >= rawSnap.Stddev {
+		t.Errorf("trimmed Average, Stddev = %v, %v; want %v, less than %v", s.Average, s.Stddev, want, rawSnap.Stddev)
+	}
+	if last := s.Histogram[len(s.Histogram)-1].Mark; !approx(last, 0.050) {
+		t.Errorf("trimmed histogram ends at %v; want 0.050", last)
+	}
+	if s.Fastest != 0.010 || s.Slowest != 30 {
+		t.Errorf("Fastest, Slowest = %v, %v; want the untrimmed 0.010, 30", s.Fastest, s.Slowest)
+	}
+
+	if got := trim([]float64{1, 2, 3}, 50); !reflect.DeepEqual(got, []float64{2}) {
+		t.Errorf("trim by 50%% = %v; want [2]", got)
+	}
+}
+
+// TestLiveSnapshot is meant to be run with -race.
+func TestLiveSnapshot(t *testing.T) {
+	const n = 2000
+	r := newReport(io.Discard, make(chan *result, 10), "", n, 0)
+	go func() {
+		for i := 0; i < n; i++ {
+			res := &result{statusCode: 200, duration: ms(float64(1 + i%50)), offset: ms(float64(i))}
+			if i%10 == 0 {
+				res = &result{err: errors.New("boom"), offset: ms(float64(i))}
+			}
+			r.results <- res
+		}
+		close(r.results)
+	}()
+	go runReporter(r)
+
+	var last int64
+	for done := false; !done; {
+		select {
+		case <-r.done:
+			done = true
+		default:
+		}
+		s := r.snapshot()
+		if s.NumRes < last {
+			t.Fatalf("NumRes went from %d down to %d", last, s.NumRes)
+		}
+		last = s.NumRes
+		var errs int
+		for _, num := range s.ErrorDist {
+			errs += num
+		}
+		if int64(errs) != s.NumErrs || s.NumRes-s.NumErrs != int64(len(s.Lats)) {
+			t.Fatalf("inconsistent snapshot: %d requests, %d errors, %d in ErrorDist, %d latencies",
+				s.NumRes, s.NumErrs, errs, len(s.Lats))
+		}
+	}
+	if s := r.snapshot(); s.NumRes != n || s.NumErrs != n/10 {
+		t.Errorf("final NumRes, NumErrs = %d, %d; want %d, %d", s.NumRes, s.NumErrs, n, n/10)
+	}
+}
+
+func TestSnapshotAveragesDuringRun(t *testing.T) {
+	r := newTestReport(4)
+	r.started = time.Now().Add(-2 * time.Second)
+	for i := 0; i < 4; i++ {
+		r.add(&result{statusCode: 200, duration: ms(20), connDuration: ms(4), resDuration: ms(2), contentLength: 1000}, nil, true)
+	}
+	s := r.snapshot()
+	if !approx(s.Average, 0.020) || !approx(s.AvgConn, 0.004) || !approx(s.AvgRes, 0.002) {
+		t.Errorf("Average, AvgConn, AvgRes = %v, %v, %v; want 0.020, 0.004, 0.002", s.Average, s.AvgConn, s.AvgRes)
+	}
+	if s.Rps <= 0 || s.Rps > 2 {
+		t.Errorf("Rps = %v; want about 2", s.Rps)
+	}
+	// The other rates are over the same elapsed time.
+	if s.Goodput != s.Rps || s.MBPerSec <= 0 {
+		t.Errorf("Goodput, MBPerSec = %v, %v; want %v, more than 0", s.Goodput, s.MBPerSec, s.Rps)
+	}
+	// The snapshot does not compute the averages of the report itself.
+	if !approx(r.avgTotal, 0.080) {
+		t.Errorf("avgTotal = %v after the snapshot; want the sum, 0.080", r.avgTotal)
+	}
+	r.aggregate(time.Second)
+	if s := r.snapshot(); !approx(s.Average, 0.020) || !approx(s.Rps, 4) {
+		t.Errorf("final Average, Rps = %v, %v; want 0.020, 4", s.Average, s.Rps)
+	}
+}
+
+func TestBucketBounds(t *testing.T) {
+	r := newTestReport(0)
+	r.bucketBounds = []float64{0.001, 0.005, 0.010, 0.050}
+	data := []float64{0.0005, 0.001, 0.003, 0.007, 0.008, 0.010, 0.020, 0.049, 0.060, 0.900}
+	want := []Bucket{
+		{Mark: 0.001, Count: 2, Frequency: 0.2},
+		{Mark: 0.005, Count: 1, Frequency: 0.1},
+		{Mark: 0.010, Count: 3, Frequency: 0.3},
+		{Mark: 0.050, Count: 2, Frequency: 0.2},
+		{Mark: 0.900, Count: 2, Frequency: 0.2, Overflow: true},
+	}
+	if got := r.histogram(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("histogram = %+v; want %+v", got, want)
+	}
+
+	// The bounds do not depend on the data.
+	got := r.histogram([]float64{0.002, 0.003})
+	if len(got) != 5 || got[1].Count != 2 || got[4].Mark != 0.050 || got[4].Count != 0 {
+		t.Errorf("histogram within the bounds = %+v; want 2 in the second bucket and an empty overflow", got)
+	}
+	if lines := histogramFunc(10, Seconds)(want); !strings.Contains(lines, "  >0.050 [2]\t|") {
+		t.Errorf("histogram does not print the overflow bucket as above the last bound:\n%s", lines)
+	}
+}
+
+func TestMAD(t *testing.T) {
+	tests := []struct {
+		data []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{5}, 0},
+		// Median 3, deviations 0, 1, 1, 2 and 97.
+		{[]float64{1, 2, 3, 4, 100}, 1},
+		// Median 3.5, deviations 0.5, 0.5, 1.5, 1.5, 2.5 and 2.5.
+		{[]float64{1, 2, 3, 4, 5, 6}, 1.5},
+		// The outlier replaces a deviation of 2.5 with one of 96.5.
+		{[]float64{1, 2, 3, 4, 5, 100}, 1.5},
+		{[]float64{1, 1, 1, 1, 9}, 0},
+	}
+	for _, tt := range tests {
+		if got := mad(tt.data); !approx(got, tt.want) {
+			t.Errorf("mad(%v) = %v; want %v", tt.data, got, tt.want)
+		}
+	}
+	if a, b := stddev([]float64{1, 2, 3, 4, 5, 6}), stddev([]float64{1, 2, 3, 4, 5, 100}); b < 10*a {
+		t.Errorf("stddev = %v and %v with the outlier; want the outlier to inflate it", a, b)
+	}
+
+	r := newTestReport(5)
+	feed(r,
+		&result{statusCode: 200, duration: ms(40)},
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(30)},
+		&result{statusCode: 200, duration: ms(1000)},
+		&result{statusCode: 200, duration: ms(20)},
+	)
+	if s := r.snapshot(); !approx(s.MAD, 0.010) {
+		t.Errorf("MAD = %v; want 0.010", s.MAD)
+	}
+}
+
+func TestReportFromSamples(t *testing.T) {
+	rep := ReportFromSamples(RawSamples{
+		Lats:        []float64{0.040, 0.010, 0.030, 0.020},
+		ConnLats:    []float64{0.004, 0.002},
+		ResLats:     []float64{0.010, 0.002, 0.004, 0.004},
+		StatusCodes: []int{200, 200, 500, 200},
+		ErrorDist:   map[string]int{"timeout": 1},
+		Total:       2 * time.Second,
+	})
+	if rep.NumRes != 5 || rep.NumErrs != 1 || !approx(rep.ErrorRate, 0.2) || !approx(rep.Rps, 2.5) {
+		t.Errorf("NumRes, NumErrs, ErrorRate, Rps = %d, %d, %v, %v; want 5, 1, 0.2, 2.5",
+			rep.NumRes, rep.NumErrs, rep.ErrorRate, rep.Rps)
+	}
+	if !approx(rep.Average, 0.025) || !approx(rep.AvgConn, 0.0015) || !approx(rep.AvgTTFB, 0.020) {
+		t.Errorf("Average, AvgConn, AvgTTFB = %v, %v, %v; want 0.025, 0.0015, 0.020", rep.Average, rep.AvgConn, rep.AvgTTFB)
+	}
+	if !approx(rep.Fastest, 0.010) || !approx(rep.Slowest, 0.040) || !approx(rep.Median, 0.025) {
+		t.Errorf("Fastest, Slowest, Median = %v, %v, %v; want 0.010, 0.040, 0.025", rep.Fastest, rep.Slowest, rep.Median)
+	}
+	for _, d := range rep.LatencyDistribution {
+		if d.Percentage == 50 && !approx(d.Latency, 0.020) || d.Percentage == 90 && !approx(d.Latency, 0.040) {
+			t.Errorf("p%v = %v", d.Percentage, d.Latency)
+		}
+	}
+	if rep.StatusCodeDist[200] != 3 || rep.StatusCodeDist[500] != 1 {
+		t.Errorf("StatusCodeDist = %v; want 3 200s and a 500", rep.StatusCodeDist)
+	}
+}
+
+func TestDelayDominated(t *testing.T) {
+	phases := func(delay, res float64) *result {
+		return &result{statusCode: 200, duration: ms(10 + delay + res), connDuration: ms(10), delayDuration: ms(delay), resDuration: ms(res)}
+	}
+	tests := []struct {
+		res       *result
+		fraction  float64
+		dominated bool
+	}{
+		{phases(80, 10), 0.8, true},
+		{phases(2, 8), 0.1, false},
+	}
+	for _, tt := range tests {
+		r := newTestReport(2)
+		feed(r, tt.res, tt.res)
+		r.finalize(time.Second)
+		s := r.snapshot()
+		if !approx(s.DelayFraction, tt.fraction) || s.DelayDominated != tt.dominated {
+			t.Errorf("delay of %v: DelayFraction, DelayDominated = %v, %v; want %v, %v",
+				tt.res.delayDuration, s.DelayFraction, s.DelayDominated, tt.fraction, tt.dominated)
+		}
+	}
+}
+
+func TestFractionUnder(t *testing.T) {
+	r := newTestReport(10)
+	var results []*result
+	for i := 10; i >= 1; i-- {
+		results = append(results, &result{statusCode: 200, duration: ms(float64(10 * i))})
+	}
+	feed(r, results...)
+	r.finalize(time.Second)
+	rep := r.snapshot()
+	unsorted := Report{Lats: rep.Lats}
+	tests := []struct {
+		latency float64
+		want    float64
+	}{
+		{0.005, 0},
+		{0.01, 0.1},
+		{0.055, 0.5},
+		{0.1, 1},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		if got := rep.FractionUnder(tt.latency); !approx(got, tt.want) {
+			t.Errorf("FractionUnder(%v) = %v; want %v", tt.latency, got, tt.want)
+		}
+		if got := unsorted.FractionUnder(tt.latency); !approx(got, tt.want) {
+			t.Errorf("FractionUnder(%v) of Lats = %v; want %v", tt.latency, got, tt.want)
+		}
+	}
+	if got := (Report{}).FractionUnder(1); got != 0 {
+		t.Errorf("FractionUnder of an empty report = %v; want 0", got)
+	}
+}
+
+func TestErrorOffsets(t *testing.T) {
+	r := newTestReport(4)
+	feed(r,
+		&result{err: errors.New("boom"), offset: 8 * time.Second},
+		&result{statusCode: 200, duration: ms(10), offset: 9 * time.Second},
+		&result{err: errors.New("boom"), offset: 2 * time.Second},
+		&result{statusCode: 200, duration: ms(10), offset: time.Second},
+	)
+	r.finalize(10 * time.Second)
+	s := r.snapshot()
+	if s.FirstErrorOffset != 2 || s.LastErrorOffset != 8 {
+		t.Errorf("FirstErrorOffset, LastErrorOffset = %v, %v; want 2, 8", s.FirstErrorOffset, s.LastErrorOffset)
+	}
+
+	// Another run, e.g. on another machine, failed earlier.
+	other := newTestReport(1)
+	feed(other, &result{err: errors.New("boom"), offset: time.Second})
+	r.merge(other)
+	if s := r.snapshot(); s.FirstErrorOffset != 1 || s.LastErrorOffset != 8 {
+		t.Errorf("merged: FirstErrorOffset, LastErrorOffset = %v, %v; want 1, 8", s.FirstErrorOffset, s.LastErrorOffset)
+	}
+
+	ok := newTestReport(1)
+	feed(ok, &result{statusCode: 200, duration: ms(10), offset: time.Second})
+	ok.finalize(time.Second)
+	if s := ok.snapshot(); s.FirstErrorOffset != -1 || s.LastErrorOffset != -1 {
+		t.Errorf("no errors: FirstErrorOffset, LastErrorOffset = %v, %v; want -1, -1", s.FirstErrorOffset, s.LastErrorOffset)
+	}
+}
+
+func TestErrorPhaseDist(t *testing.T) {
+	r := newTestReport(5)
+	feed(r,
+		&result{err: errors.New("no such host"), errPhase: ErrPhaseDNS},
+		&result{err: syscall.ECONNREFUSED, errPhase: ErrPhaseConnect},
+		&result{err: io.ErrUnexpectedEOF, errPhase: ErrPhaseRead},
+		&result{err: io.EOF, errPhase: ErrPhaseRead},
+		&result{statusCode: 200, duration: ms(10)},
+	)
+	r.finalize(time.Second)
+	want := map[string]int{ErrPhaseDNS: 1, ErrPhaseConnect: 1, ErrPhaseRead: 2}
+	if got := r.snapshot().ErrorPhaseDist; !reflect.DeepEqual(got, want) {
+		t.Errorf("ErrorPhaseDist = %v; want %v", got, want)
+	}
+}
+
+// skipReport returns a report of n results of random latencies whose
+// histogram and phase statistics are skipped as configured.
+func skipReport(n int, skip bool) *report {
+	rng := rand.New(rand.NewSource(1))
+	r := newTestReport(n)
+	r.skipHistogram, r.skipPhaseStats = skip, skip
+	results := make([]*result, n)
+	for i := range results {
+		d := rng.ExpFloat64() / 100
+		results[i] = &result{statusCode: 200, duration: ms(d * 1000), connDuration: ms(d * 100), delayDuration: ms(d * 800)}
+	}
+	feed(r, results...)
+	r.finalize(time.Second)
+	return r
+}
+
+func TestSkipHistogramAndPhaseStats(t *testing.T) {
+	full := skipReport(1000, false).snapshot()
+	skipped := skipReport(1000, true).snapshot()
+	if len(skipped.Histogram) != 0 || skipped.ConnMax != 0 || skipped.DelayMin != 0 {
+		t.Errorf("Histogram, ConnMax, DelayMin = %v, %v, %v; want them empty", skipped.Histogram, skipped.ConnMax, skipped.DelayMin)
+	}
+	if full.Average != skipped.Average || full.Median != skipped.Median || full.Fastest != skipped.Fastest ||
+		full.Slowest != skipped.Slowest || full.Stddev != skipped.Stddev || full.Rps != skipped.Rps {
+		t.Errorf("summary differs when skipping:\ngot  %v\nwant %v", skipped, full)
+	}
+	for i, d := range skipped.LatencyDistribution {
+		if d.Latency != full.LatencyDistribution[i].Latency {
+			t.Errorf("p%v = %v; want %v", d.Percentage, d.Latency, full.LatencyDistribution[i].Latency)
+		}
+		if d.ConnLatency != 0 {
+			t.Errorf("p%v of the connection = %v; want 0", d.Percentage, d.ConnLatency)
+		}
+	}
+}
+
+func BenchmarkSnapshot(b *testing.B) {
+	for _, skip := range []bool{false, true} {
+		r := skipReport(200000, skip)
+		b.Run(fmt.Sprintf("skip=%v", skip), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r.snapshot()
+			}
+		})
+	}
+}
+
+func TestMeanCI(t *testing.T) {
+	r := newTestReport(40)
+	var res []*result
+	for i := 0; i < 40; i++ {
+		res = append(res, &result{statusCode: 200, duration: ms(float64(10 + 20*(i%2)))})
+	}
+	feed(r, res...)
+	r.finalize(time.Second)
+	s := r.snapshot()
+	// The average is 20ms with a deviation of 10ms.
+	d := 1.96 * 0.01 / math.Sqrt(40)
+	if !approx(s.MeanCILow, 0.02-d) || !approx(s.MeanCIHigh, 0.02+d) {
+		t.Errorf("MeanCILow, MeanCIHigh = %v, %v; want %v, %v", s.MeanCILow, s.MeanCIHigh, 0.02-d, 0.02+d)
+	}
+
+	few := newTestReport(29)
+	feed(few, res[:29]...)
+	few.finalize(time.Second)
+	if s := few.snapshot(); s.MeanCILow != 0 || s.MeanCIHigh != 0 {
+		t.Errorf("29 samples: MeanCILow, MeanCIHigh = %v, %v; want 0, 0", s.MeanCILow, s.MeanCIHigh)
+	}
+}
+
+func TestOnResult(t *testing.T) {
+	r := newTestReport(3)
+	var got []Result
+	r.onResult = func(res Result) { got = append(got, res) }
+	boom := errors.New("boom")
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), connDuration: ms(2), contentLength: 100, offset: time.Second},
+		&result{err: boom, errPhase: ErrPhaseConnect, duration: ms(5)},
+		&result{statusCode: 503, duration: ms(30), resDuration: ms(4), connReused: true},
+	)
+	want := []Result{
+		{StatusCode: 200, Duration: ms(10), ConnDuration: ms(2), ContentLength: 100, Offset: time.Second},
+		{Err: boom, ErrPhase: ErrPhaseConnect, Duration: ms(5)},
+		{StatusCode: 503, Duration: ms(30), ResDuration: ms(4), ConnReused: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OnResult got %+v; want %+v", got, want)
+	}
+}
+
+func TestDistinctConnections(t *testing.T) {
+	r := newTestReport(5)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), connID: "127.0.0.1:50001"},
+		&result{statusCode: 200, duration: ms(10), connID: "127.0.0.1:50002", connReused: true},
+		&result{statusCode: 200, duration: ms(10), connID: "127.0.0.1:50001", connReused: true},
+		&result{err: errors.New("boom"), connID: "127.0.0.1:50003"},
+		&result{err: errors.New("dial failed")},
+	)
+	if got := r.snapshot().DistinctConnections; got != 3 {
+		t.Errorf("DistinctConnections = %d; want 3", got)
+	}
+
+	other := newTestReport(2)
+	feed(other,
+		&result{statusCode: 200, duration: ms(10), connID: "127.0.0.1:50002"},
+		&result{statusCode: 200, duration: ms(10), connID: "10.0.0.2:40000"},
+	)
+	r.merge(other)
+	if got := r.snapshot().DistinctConnections; got != 4 {
+		t.Errorf("merged: DistinctConnections = %d; want 4", got)
+	}
+}
+
+func TestNormalizedDistribution(t *testing.T) {
+	r := newTestReport(1000)
+	r.percentiles = []float64{50, 90, 99}
+	var results []*result
+	for i := 1; i <= 1000; i++ {
+		results = append(results, &result{statusCode: 200, duration: ms(float64(i))})
+	}
+	feed(r, results...)
+	got := r.snapshot().NormalizedDistribution
+	want := []NormalizedPercentile{
+		{Percentage: 50, RatioToMedian: 1},
+		{Percentage: 90, RatioToMedian: 1.8},
+		{Percentage: 99, RatioToMedian: 1.98},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("NormalizedDistribution = %+v; want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Percentage != want[i].Percentage || !approx(got[i].RatioToMedian, want[i].RatioToMedian) {
+			t.Errorf("got %+v; want %+v", got[i], want[i])
+		}
+	}
+
+	// Without a median, there is nothing to normalize by.
+	zero := newTestReport(2)
+	feed(zero, &result{statusCode: 200}, &result{statusCode: 200})
+	if got := zero.snapshot().NormalizedDistribution; got != nil {
+		t.Errorf("zero median: NormalizedDistribution = %+v; want nil", got)
+	}
+}
+
+func TestProgressWindow(t *testing.T) {
+	r := newTestReport(300)
+	progress := &bytes.Buffer{}
+	r.diagW = progress
+	r.progressInterval = 100
+	r.window = newLatencyWindow(50)
+	// Slow responses first, then fast ones: the p99 of the window only
+	// covers the latest, fast ones.
+	var results []*result
+	for i := 0; i < 300; i++ {
+		d := ms(100)
+		if i >= 150 {
+			d = ms(10)
+		}
+		results = append(results, &result{statusCode: 200, duration: d})
+	}
+	feed(r, results...)
+	updates := strings.Split(strings.TrimSpace(progress.String()), "\r")
+	if len(updates) != 3 {
+		t.Fatalf("got %d progress updates; want 3: %q", len(updates), progress)
+	}
+	for i, want := range []string{"0.1000 secs", "0.0100 secs", "0.0100 secs"} {
+		if want = ", p99 of the last 50: " + want; !strings.HasSuffix(updates[i], want) {
+			t.Errorf("update %d = %q; want it to end in %q", i, updates[i], want)
+		}
+	}
+	if p99 := r.snapshot().LatencyDistribution; p99[len(p99)-1].Latency != 0.1 {
+		t.Errorf("p99 of the run = %v; want 0.1", p99[len(p99)-1].Latency)
+	}
+}
+
+func TestLatencyWindow(t *testing.T) {
+	w := newLatencyWindow(3)
+	if w.len() != 0 || w.percentile(99, NearestRank) != 0 {
+		t.Errorf("empty window: len, p99 = %d, %v; want 0, 0", w.len(), w.percentile(99, NearestRank))
+	}
+	for _, v := range []float64{5, 1, 2} {
+		w.add(v)
+	}
+	if w.len() != 3 || w.percentile(100, NearestRank) != 5 {
+		t.Errorf("full window: len, max = %d, %v; want 3, 5", w.len(), w.percentile(100, NearestRank))
+	}
+	// The oldest latency, 5, is replaced.
+	w.add(3)
+	if w.len() != 3 || w.percentile(100, NearestRank) != 3 {
+		t.Errorf("after wrapping: len, max = %d, %v; want 3, 3", w.len(), w.percentile(100, NearestRank))
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	// Requests over [0, 4), [1, 3), [2, 5) and [5, 6): three in flight at
+	// most, during [2, 3). The last one starts as the third one ends.
+	offsets := []float64{0, 1, 2, 5}
+	lats := []float64{4, 2, 3, 1}
+	peak, avg := concurrency(offsets, lats)
+	if peak != 3 {
+		t.Errorf("peak = %d; want 3", peak)
+	}
+	// 10 seconds of requests over 6 seconds.
+	if !approx(avg, 10.0/6) {
+		t.Errorf("avg = %v; want %v", avg, 10.0/6)
+	}
+
+	if peak, avg := concurrency(nil, nil); peak != 0 || avg != 0 {
+		t.Errorf("no requests: peak, avg = %d, %v; want 0, 0", peak, avg)
+	}
+
+	r := newTestReport(3)
+	feed(r,
+		&result{statusCode: 200, duration: ms(100), offset: 0},
+		&result{statusCode: 200, duration: ms(100), offset: ms(50)},
+		&result{statusCode: 200, duration: ms(100), offset: ms(200)},
+	)
+	if s := r.snapshot(); s.PeakConcurrency != 2 || !approx(s.AvgConcurrency, 1) {
+		t.Errorf("PeakConcurrency, AvgConcurrency = %d, %v; want 2, 1", s.PeakConcurrency, s.AvgConcurrency)
+	}
+}
+
+func TestOutputMode(t *testing.T) {
+	for _, output := range []string{"", "json", "ndjson", "{{ .Rps }}"} {
+		r := newReport(io.Discard, make(chan *result), output, 1, 0)
+		if got := r.snapshot().OutputMode; got != output {
+			t.Errorf("OutputMode = %q; want %q", got, output)
+		}
+	}
+}
+
+func TestReportRecord(t *testing.T) {
+	var out bytes.Buffer
+	r := newReport(&out, nil, "{{ .NumRes }} {{ .NumErrs }} {{ .Fastest }}", 2, 0)
+	var rep Reporter = r
+	rep.Record(Result{StatusCode: 200, Duration: ms(10)})
+	rep.Record(Result{Err: errors.New("boom")})
+	if err := rep.Finalize(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "2 1 0.01\n"; got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+}
+
+func TestResultRoundTrip(t *testing.T) {
+	res := &result{
+		err: errors.New("boom"), errPhase: ErrPhaseDNS, statusCode: 200,
+		offset: ms(1), duration: ms(2), connDuration: ms(3), dnsDuration: ms(4), tlsDuration: ms(5),
+		reqDuration: ms(6), resDuration: ms(7), delayDuration: ms(8),
+		contentLength: 9, reqSize: 10, connReused: true, weight: 11, attempts: 12, connID: "conn",
+	}
+	if got := res.public().private(); !reflect.DeepEqual(got, res) {
+		t.Errorf("round trip = %+v; want %+v", got, res)
+	}
+
+	// A custom reporter feeding the builtin one keeps the weights, the
+	// retries and the connections.
+	r := newReport(io.Discard, nil, "", 2, 0)
+	r.Record((&result{statusCode: 200, duration: ms(10), weight: 3, attempts: 2, connID: "a"}).public())
+	r.Record((&result{statusCode: 200, duration: ms(30), connID: "b"}).public())
+	r.aggregate(time.Second)
+	s := r.snapshot()
+	if !approx(s.Average, 0.015) || s.TotalAttempts != 3 || s.RetriedRequests != 1 || s.DistinctConnections != 2 {
+		t.Errorf("Average, TotalAttempts, RetriedRequests, DistinctConnections = %v, %d, %d, %d; want 0.015, 3, 1, 2",
+			s.Average, s.TotalAttempts, s.RetriedRequests, s.DistinctConnections)
+	}
+}
+
+func TestBootstrapPercentile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	width := func(n int) float64 {
+		var results []*result
+		for i := 0; i < n; i++ {
+			results = append(results, &result{statusCode: 200, duration: time.Duration(rng.ExpFloat64() * float64(10*time.Millisecond))})
+		}
+		r := newTestReport(n)
+		r.percentiles = []float64{95}
+		r.bootstrap = 200
+		r.bootstrapSeed = 1
+		feed(r, results...)
+		s := r.snapshot()
+		p95 := s.LatencyDistribution[0].Latency
+		if !(s.P95CILow <= p95 && p95 <= s.P95CIHigh) || s.P95CILow == s.P95CIHigh {
+			t.Errorf("%d samples: band [%v, %v] does not bracket p95 %v", n, s.P95CILow, s.P95CIHigh, p95)
+		}
+		// The snapshots resample alike.
+		if again := r.snapshot(); again.P95CILow != s.P95CILow || again.P95CIHigh != s.P95CIHigh {
+			t.Errorf("%d samples: band differs between snapshots", n)
+		}
+		return s.P95CIHigh - s.P95CILow
+	}
+	if small, large := width(50), width(5000); large >= small {
+		t.Errorf("band of 5000 samples is %v wide; want it narrower than that of 50, %v", large, small)
+	}
+
+	r := newTestReport(1)
+	feed(r, &result{statusCode: 200, duration: ms(10)})
+	if s := r.snapshot(); s.P95CILow != 0 || s.P95CIHigh != 0 {
+		t.Errorf("not bootstrapped: band = [%v, %v]; want zeros", s.P95CILow, s.P95CIHigh)
+	}
+}
+
+func TestGoodput(t *testing.T) {
+	r := newTestReport(6)
+	feed(r,
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 204, duration: ms(10)},
+		&result{statusCode: 302, duration: ms(10)},
+		&result{statusCode: 404, duration: ms(10)},
+		&result{statusCode: 500, duration: ms(10)},
+		&result{err: errors.New("boom")},
+	)
+	r.finalize(2 * time.Second)
+	s := r.snapshot()
+	if s.Rps != 3 {
+		t.Errorf("Rps = %v; want 3", s.Rps)
+	}
+	if s.Goodput != 1.5 {
+		t.Errorf("Goodput = %v; want 1.5 of the 2xx and 3xx responses", s.Goodput)
+	}
+
+	rep := ReportFromSamples(RawSamples{
+		Lats:        []float64{0.01, 0.02, 0.03, 0.04},
+		StatusCodes: []int{200, 200, 503, 301},
+		Total:       time.Second,
+	})
+	if rep.Goodput != 3 {
+		t.Errorf("from samples: Goodput = %v; want 3", rep.Goodput)
+	}
+
+	// The samples without a status code are not known to be successful.
+	rep = ReportFromSamples(RawSamples{
+		Lats:        []float64{0.01, 0.02, 0.03, 0.04},
+		StatusCodes: []int{200, 503},
+		Total:       time.Second,
+	})
+	if rep.Goodput != 1 {
+		t.Errorf("from samples without status codes: Goodput = %v; want 1", rep.Goodput)
+	}
+	if want := map[int]int{200: 1, 503: 1}; !reflect.DeepEqual(rep.StatusCodeDist, want) {
+		t.Errorf("from samples without status codes: StatusCodeDist = %v; want %v", rep.StatusCodeDist, want)
+	}
+	if len(rep.StatusCodeCounts) != 2 {
+		t.Errorf("from samples without status codes: StatusCodeCounts = %+v; want 2 codes", rep.StatusCodeCounts)
+	}
+	if _, ok := rep.StatusLatencies[0]; ok || len(rep.StatusLatencies) != 2 {
+		t.Errorf("from samples without status codes: StatusLatencies = %+v; want 200 and 503 only", rep.StatusLatencies)
+	}
+	if len(rep.SlowestN) != 2 || rep.SlowestN[0].StatusCode != 503 || rep.SlowestN[1].StatusCode != 200 {
+		t.Errorf("from samples without status codes: SlowestN = %+v; want the 503 and the 200", rep.SlowestN)
+	}
+}
+
+func TestFileReportAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.ndjson")
+	for i := 1; i <= 2; i++ {
+		r, err := newFileReport(path, true, make(chan *result, i), "json", i, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []*result
+		for j := 0; j < i; j++ {
+			results = append(results, &result{statusCode: 200, duration: ms(10)})
+		}
+		feed(r, results...)
+		if err := r.finalize(time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("file holds %d lines; want a report per run:\n%s", len(lines), data)
+	}
+	var ids []string
+	for i, line := range lines {
+		var rep Report
+		if err := json.Unmarshal([]byte(line), &rep); err != nil {
+			t.Fatalf("line %d is not a JSON report: %v", i+1, err)
+		}
+		if rep.NumRes != int64(i+1) || rep.RunID == "" || rep.StartTime.IsZero() {
+			t.Errorf("line %d: NumRes, RunID, StartTime = %d, %q, %v; want %d, an id and a time", i+1, rep.NumRes, rep.RunID, rep.StartTime, i+1)
+		}
+		ids = append(ids, rep.RunID)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("both runs have the id %q", ids[0])
+	}
+}
+
+func TestIQMean(t *testing.T) {
+	// Most responses take 10 to 13ms, but a few time out after 5s.
+	var results []*result
+	for i := 0; i < 20; i++ {
+		d := ms(float64(10 + i%4))
+		if i%10 == 9 {
+			d = 5 * time.Second
+		}
+		results = append(results, &result{statusCode: 200, duration: d})
+	}
+	r := newTestReport(len(results))
+	feed(r, results...)
+	r.finalize(time.Second)
+	s := r.snapshot()
+	// The middle ten latencies are 11ms four times, 12ms five times and
+	// 13ms once.
+	if want := 0.0117; !approx(s.IQMean, want) {
+		t.Errorf("IQMean = %v; want %v", s.IQMean, want)
+	}
+	if s.Average < 0.4 {
+		t.Errorf("Average = %v; want it skewed by the timeouts", s.Average)
+	}
+
+	for _, tc := range []struct {
+		data []float64
+		want float64
+	}{
+		{nil, 0},
+		{[]float64{1}, 1},
+		{[]float64{1, 2, 6}, 3},
+		{[]float64{1, 2, 3, 100}, 2.5},
+	} {
+		if got := iqMean(tc.data); !approx(got, tc.want) {
+			t.Errorf("iqMean(%v) = %v; want %v", tc.data, got, tc.want)
+		}
+	}
+}
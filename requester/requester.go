@@ -17,13 +17,20 @@ package requester
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"golang.org/x/net/http2"
@@ -45,6 +52,115 @@ type result struct {
 	resDuration   time.Duration // response "read" duration
 	delayDuration time.Duration // delay between response and request
 	contentLength int64
+	connReused    bool  // whether the request reused a kept-alive connection
+	reqSize       int64 // size of the request body
+	weight        int   // number of requests the result stands for, 0 is 1
+	attempts      int   // number of attempts, including retries, 0 is 1
+
+	// errPhase is the phase of the request err occurred in, e.g.
+	// ErrPhaseDNS.
+	errPhase string
+	// connID identifies the connection the request was made on, it is
+	// empty if the request got none.
+	connID string
+}
+
+// Result is the outcome of a request, as passed to Work.OnResult.
+type Result struct {
+	// Err is the error of the request, if it failed, and ErrPhase the
+	// phase it occurred in, e.g. ErrPhaseDNS.
+	Err      error
+	ErrPhase string
+
+	StatusCode int
+	// Offset is the start of the request since the start of the run.
+	Offset   time.Duration
+	Duration time.Duration
+
+	// ConnDuration, DNSDuration, TLSDuration, ReqDuration, DelayDuration
+	// and ResDuration are the durations of the phases of the request, as
+	// in the latency distribution of the summary.
+	ConnDuration  time.Duration
+	DNSDuration   time.Duration
+	TLSDuration   time.Duration
+	ReqDuration   time.Duration
+	DelayDuration time.Duration
+	ResDuration   time.Duration
+
+	ContentLength int64
+	ReqSize       int64
+	ConnReused    bool
+
+	// Weight is the number of requests the result stands for, and
+	// Attempts the number of attempts of the request, including retries.
+	// Zero counts as one for both.
+	Weight   int
+	Attempts int
+	// ConnID identifies the connection the request was made on, it is
+	// empty if the request got none.
+	ConnID string
+}
+
+// Reporter collects the results of a run, see Work.Reporter. The builtin
+// reporter implements it.
+type Reporter interface {
+	// Record is called with the result of every request, from a single
+	// goroutine, one result at a time.
+	Record(Result)
+	// Finalize is called once all the results are recorded, with the
+	// duration of the run.
+	Finalize(total time.Duration) error
+}
+
+func (r *result) public() Result {
+	return Result{
+		Err:           r.err,
+		ErrPhase:      r.errPhase,
+		StatusCode:    r.statusCode,
+		Offset:        r.offset,
+		Duration:      r.duration,
+		ConnDuration:  r.connDuration,
+		DNSDuration:   r.dnsDuration,
+		TLSDuration:   r.tlsDuration,
+		ReqDuration:   r.reqDuration,
+		DelayDuration: r.delayDuration,
+		ResDuration:   r.resDuration,
+		ContentLength: r.contentLength,
+		ReqSize:       r.reqSize,
+		ConnReused:    r.connReused,
+		Weight:        r.weight,
+		Attempts:      r.attempts,
+		ConnID:        r.connID,
+	}
+}
+
+func (r Result) private() *result {
+	return &result{
+		err:           r.Err,
+		errPhase:      r.ErrPhase,
+		statusCode:    r.StatusCode,
+		offset:        r.Offset,
+		duration:      r.Duration,
+		connDuration:  r.ConnDuration,
+		dnsDuration:   r.DNSDuration,
+		tlsDuration:   r.TLSDuration,
+		reqDuration:   r.ReqDuration,
+		delayDuration: r.DelayDuration,
+		resDuration:   r.ResDuration,
+		contentLength: r.ContentLength,
+		reqSize:       r.ReqSize,
+		connReused:    r.ConnReused,
+		weight:        r.Weight,
+		attempts:      r.Attempts,
+		connID:        r.ConnID,
+	}
+}
+
+// ttfb returns the time to the first byte of the response, i.e. the time
+// until the response is read. It includes the time to get a connection,
+// which covers the DNS lookup and the TLS handshake.
+func (r *result) ttfb() time.Duration {
+	return r.duration - r.resDuration
 }
 
 type Work struct {
@@ -57,6 +173,12 @@ type Work struct {
 	// Request and RequestData are cloned for each request.
 	RequestFunc func() *http.Request
 
+	// RequestWeight returns the number of requests a request stands for,
+	// e.g. when replaying sampled traffic. The average and the percentiles
+	// of the response times are then weighted. If nil, all the requests
+	// have a weight of 1.
+	RequestWeight func(*http.Request) int
+
 	// N is the total number of requests to make.
 	N int
 
@@ -81,23 +203,244 @@ type Work struct {
 	// DisableRedirects is an option to prevent the following of HTTP redirects
 	DisableRedirects bool
 
-	// Output represents the output type. If "csv" is provided, the
-	// output will be dumped as a csv stream.
+	// Output represents the output type. If "compact" is provided, a
+	// summary that fits narrow terminals is printed, and if "markdown" is
+	// provided, the summary as Markdown tables. If "csv" is provided, the
+	// output will be dumped as a csv stream. If "json" is provided,
+	// the report will be dumped as a JSON object, and if "yaml" is
+	// provided, as YAML. If "ndjson" is
+	// provided, a JSON object per request will be streamed. If
+	// "prometheus" is provided, the metrics will be dumped in the
+	// Prometheus text exposition format, and if "influx" is provided, as
+	// a line of the InfluxDB line protocol. If "html" is provided, the
+	// report will be dumped as an HTML page with charts. If "sketch" is
+	// provided, the encoded latency sketch of the report will be dumped.
 	Output string
 
+	// StreamSummary is an option to write the report as the last line
+	// of the "ndjson" output.
+	StreamSummary bool
+
 	// ProxyAddr is the address of HTTP proxy server in the format on "host:port".
 	// Optional.
 	ProxyAddr *url.URL
 
+	// Percentiles are the percentiles reported in the latency distribution,
+	// e.g. 50, 99 or 99.9. If empty, 10, 25, 50, 75, 90, 95 and 99 are
+	// reported, and 99.9 and 99.99 as well from 1000 and 10000 responses.
+	Percentiles []float64
+
+	// PercentileMethod selects how the latency distribution is computed.
+	// Defaults to NearestRank.
+	PercentileMethod PercentileMethod
+
+	// HistogramBuckets is the number of buckets of the response time
+	// histogram. Defaults to 10.
+	HistogramBuckets int
+
+	// TrimPercent is the percentage of the fastest and of the slowest
+	// requests left out of the average, the standard deviation and the
+	// histogram, so that a few outliers do not skew them. The fastest and
+	// the slowest requests are reported regardless. Weighted requests are
+	// trimmed by their weight, like the requests they stand for.
+	TrimPercent float64
+
+	// Bootstrap is an option to estimate a 95% confidence band of the p95
+	// latency by bootstrap resampling of the latencies, see
+	// Report.P95CILow. BootstrapResamples is the number of resamples,
+	// 1000 by default. The resampling draws from RandSource, and it sorts
+	// every resample: it is meant for small runs.
+	Bootstrap          bool
+	BootstrapResamples int
+
+	// HistogramBounds are fixed marks of the histogram buckets, so that
+	// the histograms of several runs are comparable. The samples above
+	// the highest bound are counted in an overflow bucket. If empty,
+	// HistogramBuckets buckets span the latencies of the run.
+	HistogramBounds []time.Duration
+
+	// PhaseHistograms is an option to add a latency histogram of each
+	// phase of the requests to the report, e.g. to spot a multimodal
+	// connection setup.
+	PhaseHistograms bool
+
+	// LogHistogram is an option to space the histogram buckets
+	// logarithmically between the fastest and the slowest response.
+	LogHistogram bool
+
+	// SkipHistogram is an option to leave the histograms out of the
+	// report, and SkipPhaseStats one to leave out the fastest, slowest
+	// and percentiles of the phases of the requests. Both save the time
+	// of computing them in large runs when only the summary numbers of
+	// the response times matter.
+	SkipHistogram  bool
+	SkipPhaseStats bool
+
+	// LatencySketch is an option to include the latency sketch in the
+	// report, see Report.LatencySketch, e.g. in the JSON output. The
+	// sketch output always has it.
+	LatencySketch bool
+
+	// BarWidth is the length of the longest bar of the response time
+	// histogram, and of the bar of the phase breakdown, in characters.
+	// Defaults to 40.
+	BarWidth int
+
+	// ThroughputWindow is the size of the windows of the throughput
+	// series of the report, in seconds. Defaults to 1.
+	ThroughputWindow int
+
+	// SlowestN is the number of slowest requests listed in the report,
+	// along with when they were made. Defaults to 10.
+	SlowestN int
+
+	// CDFPoints is the number of points of the cumulative distribution
+	// function of the latencies in the report. Defaults to 100.
+	CDFPoints int
+
+	// ApdexT is the threshold of the Apdex score of the report. If zero,
+	// no score is computed.
+	ApdexT time.Duration
+
+	// ProgressInterval is the number of results after which the progress
+	// of the run is printed to DiagWriter. If zero, no progress is printed.
+	ProgressInterval int
+
+	// ProgressWindow is the number of the latest successful requests
+	// whose p99 latency is printed with the progress, to follow the tail
+	// latency during the run. If zero, it is not printed.
+	ProgressWindow int
+
+	// MaxErrorRate is the maximum fraction of failed requests, e.g. 0.01
+	// for 1%. If it is exceeded, Run returns an error. If zero, the error
+	// rate is not checked.
+	MaxErrorRate float64
+
+	// CountTimeoutsAsLatency is an option to count timed out requests in
+	// the latencies of the report, with their duration capped at Timeout,
+	// for a more conservative estimate of the tail latencies. They are
+	// still counted as errors. Other errors are excluded from latencies.
+	CountTimeoutsAsLatency bool
+
+	// MaxSamples is the maximum number of results whose samples are
+	// retained for the report. Defaults to a million.
+	MaxSamples int
+
+	// SLO are rules on the metrics of the report. If any of them fails,
+	// Run returns an error.
+	SLO []SLORule
+
+	// Quiet is an option to print the report only if the run fails
+	// MaxErrorRate or the SLO rules, in which case Run returns the reason.
+	// The rows of the csv and ndjson outputs are written regardless, as
+	// the results arrive.
+	Quiet bool
+
+	// ReservoirSampling is an option to retain a uniform random sample
+	// of the results once more results than can be retained arrive.
+	// By default, the results after the first MaxSamples are dropped.
+	ReservoirSampling bool
+
+	// StreamingPercentiles is an option to estimate the percentiles of
+	// the latencies as the results arrive, with the P² algorithm, rather
+	// than to retain the samples of the results. The memory of the report
+	// stays constant however long the run, but the percentiles are
+	// estimates, usually within a few percent, and the histogram and the
+	// other statistics computed from the samples are left out.
+	StreamingPercentiles bool
+
+	// RandSource is the source of the randomness of the reservoir
+	// sampling. Setting a seeded source makes the sampling reproducible.
+	// If nil, a source seeded with the current time is used.
+	RandSource rand.Source
+
+	// Warmup is the duration at the start of the run whose requests are
+	// discarded from the report, so that it covers the steady state only.
+	Warmup time.Duration
+
+	// LatencyUnit is the unit of the latencies in the summary, seconds
+	// by default. The values of the report are in seconds regardless.
+	LatencyUnit LatencyUnit
+
+	// TemplateFuncs are functions made available to the template of the
+	// summary, in addition to, or replacing, the builtin ones: formatNumber,
+	// ms, bytes, pct, markdownCell, timestamp, latency, unit and histogram.
+	// See text/template.
+	TemplateFuncs template.FuncMap
+
+	// SummaryLine is an option to end the summary output with a single
+	// line summary of the run, e.g. for log scrapers.
+	SummaryLine bool
+
+	// StructuredSummary is an option to also write the key metrics of
+	// the run to DiagWriter once it is done, as a single line in the given
+	// format: "logfmt" for key=value pairs, or "json". It is written
+	// whatever the Output, e.g. for log collectors.
+	StructuredSummary string
+
 	// Writer is where results will be written. If nil, results are written to stdout.
 	Writer io.Writer
 
+	// OutputFile is the path of a file to write results to instead of
+	// Writer. The file and its parent directories are created if needed.
+	// If the path ends in ".gz", the file is compressed with gzip.
+	OutputFile string
+
+	// AppendOutput is an option to append to OutputFile rather than to
+	// replace it, e.g. to collect the reports of several runs in a file.
+	// The "json" report is then written on a single line, so that the
+	// file holds one report per line.
+	AppendOutput bool
+
+	// ReportURL is the URL of a collector the final report is posted to
+	// as JSON, in addition to the output. If posting fails, Run returns
+	// an error.
+	ReportURL string
+
+	// SyslogTag is an option to also write the final report to syslog,
+	// tagged with it: the summary line at the INFO level and the number
+	// of each error at the WARNING level. SyslogFacility is the facility
+	// of the messages, e.g. "daemon" or "local0", "user" by default, and
+	// SyslogAddr the UDP address of the syslog server, the local one by
+	// default. There is no syslog on Windows, nothing is written there.
+	SyslogTag      string
+	SyslogFacility string
+	SyslogAddr     string
+
+	// InfluxMeasurement and InfluxTags are the measurement, "hey" by
+	// default, and the tags of the line of the "influx" output, e.g.
+	// {"host": "web-1"}.
+	InfluxMeasurement string
+	InfluxTags        map[string]string
+
+	// DiagWriter is where the progress and errors printing the results
+	// are written. If nil, they are written to stderr.
+	DiagWriter io.Writer
+
+	// OnResult is called with the result of every request as it arrives,
+	// before it is added to the report or recorded by Reporter, e.g. to
+	// record custom metrics.
+	// It is called from a single goroutine, one result at a time, and
+	// must be fast: the results queue up while it runs, and the workers
+	// stall once the queue is full.
+	OnResult func(Result)
+
+	// Reporter is an option to hand the results to a custom reporter
+	// instead of the builtin one, e.g. to push them to a queue. None of
+	// the output and the report options apply then, Run returns the
+	// error of its Finalize, and Snapshot returns an empty report.
+	Reporter Reporter
+
 	initOnce sync.Once
 	results  chan *result
 	stopCh   chan struct{}
 	start    time.Duration
 
 	report *report
+	// reporterDone is closed once Reporter has recorded all the results.
+	reporterDone chan struct{}
+	// live is the report of the run in progress, for Snapshot.
+	live atomic.Pointer[report]
 }
 
 func (b *Work) writer() io.Writer {
@@ -116,17 +459,179 @@ func (b *Work) Init() {
 }
 
 // Run makes all the requests, prints the summary. It blocks until
-// all work is done.
-func (b *Work) Run() {
+// all work is done. It returns an error if the run failed the checks
+// of the report, e.g. if the error rate exceeds MaxErrorRate.
+func (b *Work) Run() error {
+	return b.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops making requests when ctx is done.
+// The summary then covers the requests made so far, and is marked as
+// partial. Its duration is the time until ctx was done.
+func (b *Work) RunContext(ctx context.Context) error {
 	b.Init()
 	b.start = now()
-	b.report = newReport(b.writer(), b.results, b.Output, b.N)
-	// Run the reporter first, it polls the result channel until it is closed.
+	if b.Reporter != nil {
+		b.reporterDone = make(chan struct{})
+		go func() {
+			for res := range b.results {
+				pub := res.public()
+				if b.OnResult != nil {
+					b.OnResult(pub)
+				}
+				b.Reporter.Record(pub)
+			}
+			close(b.reporterDone)
+		}()
+	} else {
+		if err := b.initReport(); err != nil {
+			return err
+		}
+		// Run the reporter first, it polls the result channel until it
+		// is closed.
+		go func() {
+			runReporter(b.report)
+		}()
+	}
+	done := make(chan struct{})
+	stopped := make(chan time.Duration, 1)
 	go func() {
-		runReporter(b.report)
+		select {
+		case <-ctx.Done():
+			stopped <- now() - b.start
+		case <-done:
+		}
+		close(stopped)
 	}()
-	b.runWorkers()
-	b.Finish()
+	b.runWorkers(ctx)
+	close(done)
+	if total, ok := <-stopped; ok {
+		if b.report != nil {
+			// Snapshot may read it from another goroutine.
+			b.report.mu.Lock()
+			b.report.partial = true
+			b.report.mu.Unlock()
+		}
+		return b.finish(total)
+	}
+	return b.Finish()
+}
+
+// initReport creates the report of the run, configured by b.
+func (b *Work) initReport() error {
+	if b.OutputFile != "" {
+		var err error
+		if b.report, err = newFileReport(b.OutputFile, b.AppendOutput, b.results, b.Output, b.N, b.MaxSamples); err != nil {
+			return err
+		}
+	} else {
+		b.report = newReport(b.writer(), b.results, b.Output, b.N, b.MaxSamples)
+	}
+	b.configureReport(b.report)
+	b.live.Store(b.report)
+	return nil
+}
+
+// configureReport sets the options of the run on r.
+func (b *Work) configureReport(r *report) {
+	r.percentiles = b.Percentiles
+	r.pctlMethod = b.PercentileMethod
+	r.streamSummary = b.StreamSummary
+	r.summaryLine = b.SummaryLine
+	r.structuredSummary = b.StructuredSummary
+	r.funcs = b.TemplateFuncs
+	r.latencyUnit = b.LatencyUnit
+	r.histogramBuckets = b.HistogramBuckets
+	r.logHistogram = b.LogHistogram
+	r.phaseHistograms = b.PhaseHistograms
+	r.skipHistogram = b.SkipHistogram
+	r.skipPhaseStats = b.SkipPhaseStats
+	r.latencySketch = b.LatencySketch
+	for _, bound := range b.HistogramBounds {
+		r.bucketBounds = append(r.bucketBounds, bound.Seconds())
+	}
+	sort.Float64s(r.bucketBounds)
+	r.trimPercent = b.TrimPercent
+	r.barWidth = b.BarWidth
+	r.throughputWindow = b.ThroughputWindow
+	r.slowestN = b.SlowestN
+	r.cdfPoints = b.CDFPoints
+	r.apdexT = b.ApdexT.Seconds()
+	r.targetRps = b.QPS * float64(b.C)
+	r.progressInterval = b.ProgressInterval
+	if b.ProgressWindow > 0 {
+		r.window = newLatencyWindow(b.ProgressWindow)
+	}
+	r.maxErrorRate = b.MaxErrorRate
+	r.countTimeoutsAsLatency = b.CountTimeoutsAsLatency
+	r.timeout = time.Duration(b.Timeout) * time.Second
+	r.slo = b.SLO
+	r.quiet = b.Quiet
+	r.onResult = b.OnResult
+	r.reportURL = b.ReportURL
+	r.syslogTag = b.SyslogTag
+	r.syslogFacility = b.SyslogFacility
+	r.syslogAddr = b.SyslogAddr
+	r.influxMeasurement = b.InfluxMeasurement
+	r.influxTags = b.InfluxTags
+	r.reservoir = b.ReservoirSampling
+	if b.StreamingPercentiles {
+		r.streamPercentiles()
+	}
+	if b.RandSource != nil {
+		r.rng = rand.New(b.RandSource)
+	}
+	if b.Bootstrap {
+		r.bootstrap = b.BootstrapResamples
+		if r.bootstrap <= 0 {
+			r.bootstrap = defaultBootstrapResamples
+		}
+		r.bootstrapSeed = r.rng.Int63()
+	}
+	r.warmup = b.Warmup.Seconds()
+	if b.DiagWriter != nil {
+		r.diagW = b.DiagWriter
+	}
+}
+
+// Snapshot returns the report of the results so far, e.g. for a live
+// dashboard. It can be called from any goroutine while the run is in
+// progress, in which case the averages and the rate are of the results
+// so far. Before the run starts, it returns an empty report.
+func (b *Work) Snapshot() Report {
+	r := b.live.Load()
+	if r == nil {
+		return Report{}
+	}
+	return r.snapshot()
+}
+
+// MergeReports returns the report of the results of several runs, e.g.
+// the grand total of runs against several targets, as if they were the
+// results of a single run. The runs must be done. Its duration is the sum
+// of theirs, and its options, e.g. the percentiles and MaxSamples, are
+// those of the first run: samples beyond MaxSamples are dropped. Streamed
+// percentile estimates cannot be merged.
+func MergeReports(works ...*Work) Report {
+	if len(works) == 0 {
+		return Report{}
+	}
+	r := newReport(io.Discard, nil, "", 0, works[0].MaxSamples)
+	works[0].configureReport(r)
+	r.started = time.Time{}
+	var total time.Duration
+	var warmup float64
+	for _, w := range works {
+		if w.report == nil {
+			continue
+		}
+		r.merge(w.report)
+		total += w.report.total
+		warmup += w.report.warmup
+	}
+	r.warmup = warmup
+	r.aggregate(total)
+	return r.snapshot()
 }
 
 func (b *Work) Stop() {
@@ -136,12 +641,19 @@ func (b *Work) Stop() {
 	}
 }
 
-func (b *Work) Finish() {
+func (b *Work) Finish() error {
+	return b.finish(now() - b.start)
+}
+
+func (b *Work) finish(total time.Duration) error {
 	close(b.results)
-	total := now() - b.start
 	// Wait until the reporter is done.
+	if b.Reporter != nil {
+		<-b.reporterDone
+		return b.Reporter.Finalize(total)
+	}
 	<-b.report.done
-	b.report.finalize(total)
+	return b.report.finalize(total)
 }
 
 func (b *Work) makeRequest(c *http.Client) {
@@ -151,22 +663,36 @@ func (b *Work) makeRequest(c *http.Client) {
 	var dnsStart, tlsStart, connStart, resStart, reqStart, delayStart time.Duration
 	var dnsDuration, tlsDuration, connDuration, resDuration, reqDuration, delayDuration time.Duration
 	var tlsError error
+	var connReused bool
+	var connID string
+	// phase is the phase the request is in, that of the error if it fails.
+	var phase string
 
 	var req *http.Request
+	var reqSize int64
 	if b.RequestFunc != nil {
 		req = b.RequestFunc()
+		reqSize = max(req.ContentLength, 0)
 	} else {
 		req = cloneRequest(b.Request, b.RequestBody)
+		reqSize = int64(len(b.RequestBody))
+	}
+	var weight int
+	if b.RequestWeight != nil {
+		weight = b.RequestWeight(req)
 	}
 	trace := &httptrace.ClientTrace{
 		DNSStart: func(info httptrace.DNSStartInfo) {
 			dnsStart = now()
+			phase = ErrPhaseDNS
 		},
 		DNSDone: func(dnsInfo httptrace.DNSDoneInfo) {
 			dnsDuration = now() - dnsStart
+			phase = ErrPhaseConnect
 		},
 		TLSHandshakeStart: func() {
 			tlsStart = now()
+			phase = ErrPhaseTLS
 		},
 		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
 			tlsDuration = now() - tlsStart
@@ -174,16 +700,21 @@ func (b *Work) makeRequest(c *http.Client) {
 		},
 		GetConn: func(h string) {
 			connStart = now()
+			phase = ErrPhaseConnect
 		},
 		GotConn: func(connInfo httptrace.GotConnInfo) {
 			if !connInfo.Reused {
 				connDuration = now() - connStart
 			}
+			connReused = connInfo.Reused
+			connID = connectionID(connInfo.Conn)
 			reqStart = now()
+			phase = ErrPhaseWrite
 		},
 		WroteRequest: func(w httptrace.WroteRequestInfo) {
 			reqDuration = now() - reqStart
 			delayStart = now()
+			phase = ErrPhaseRead
 		},
 		GotFirstResponseByte: func() {
 			delayDuration = now() - delayStart
@@ -195,14 +726,18 @@ func (b *Work) makeRequest(c *http.Client) {
 	if err == nil {
 		size = resp.ContentLength
 		code = resp.StatusCode
-		io.Copy(io.Discard, resp.Body)
+		// A response cut short is a failed request.
+		_, err = io.Copy(io.Discard, resp.Body)
 		resp.Body.Close()
 	}
 	t := now()
 	resDuration = t - resStart
 	finish := t - s
+	if err == nil && tlsError != nil {
+		err, phase = tlsError, ErrPhaseTLS
+	}
 	if err == nil {
-		err = tlsError
+		phase = ""
 	}
 	b.results <- &result{
 		offset:        s,
@@ -216,10 +751,27 @@ func (b *Work) makeRequest(c *http.Client) {
 		reqDuration:   reqDuration,
 		resDuration:   resDuration,
 		delayDuration: delayDuration,
+		connReused:    connReused,
+		connID:        connID,
+		reqSize:       reqSize,
+		weight:        weight,
+		errPhase:      phase,
 	}
 }
 
-func (b *Work) runWorker(client *http.Client, n int) {
+// connectionID returns an identifier of the connection, unique among the
+// open connections: its local address, which differs by port.
+func connectionID(conn net.Conn) string {
+	if conn == nil {
+		return ""
+	}
+	if addr := conn.LocalAddr(); addr != nil && addr.String() != "" {
+		return addr.String()
+	}
+	return fmt.Sprintf("%p", conn)
+}
+
+func (b *Work) runWorker(ctx context.Context, client *http.Client, n int) {
 	var throttle <-chan time.Time
 	if b.QPS > 0 {
 		throttle = time.Tick(time.Duration(1e6/(b.QPS)) * time.Microsecond)
@@ -235,6 +787,8 @@ func (b *Work) runWorker(client *http.Client, n int) {
 		select {
 		case <-b.stopCh:
 			return
+		case <-ctx.Done():
+			return
 		default:
 			if b.QPS > 0 {
 				// TODO: for small throttles, consider batching
@@ -245,7 +799,7 @@ func (b *Work) runWorker(client *http.Client, n int) {
 	}
 }
 
-func (b *Work) runWorkers() {
+func (b *Work) runWorkers(ctx context.Context) {
 	var wg sync.WaitGroup
 	wg.Add(b.C)
 
@@ -269,7 +823,7 @@ func (b *Work) runWorkers() {
 	// Ignore the case where b.N % b.C != 0.
 	for i := 0; i < b.C; i++ {
 		go func() {
-			b.runWorker(client, b.N/b.C)
+			b.runWorker(ctx, client, b.N/b.C)
 			wg.Done()
 		}()
 	}
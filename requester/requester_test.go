@@ -16,9 +16,16 @@ package requester
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -132,3 +139,273 @@ func TestBody(t *testing.T) {
 		t.Errorf("Expected to work 10 times, found %v", count)
 	}
 }
+
+func TestRunContextPartial(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var count int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&count, 1) == 20 {
+			cancel()
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	out := &bytes.Buffer{}
+	w := &Work{
+		Request: req,
+		N:       1000000,
+		C:       2,
+		Writer:  out,
+	}
+	// Snapshots are taken concurrently until the run returns, see -race.
+	stop := make(chan struct{})
+	snapshotted := make(chan struct{})
+	go func() {
+		defer close(snapshotted)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				w.Snapshot()
+			}
+		}
+	}()
+	start := time.Now()
+	if err := w.RunContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	close(stop)
+	<-snapshotted
+
+	rep := w.report.snapshot()
+	if !rep.Partial {
+		t.Errorf("report is not marked as partial")
+	}
+	if rep.NumRes < 20 || rep.NumRes >= 1000000 || len(rep.Lats) == 0 {
+		t.Errorf("got %d results, %d samples; want the results made until cancellation", rep.NumRes, len(rep.Lats))
+	}
+	if rep.Total <= 0 || rep.Total > elapsed {
+		t.Errorf("Total = %v; want the duration until cancellation, at most %v", rep.Total, elapsed)
+	}
+	if want := float64(rep.NumRes) / rep.Total.Seconds(); rep.Rps != want {
+		t.Errorf("Rps = %v; want %v", rep.Rps, want)
+	}
+	if !strings.Contains(out.String(), "Summary: (partial, the run was interrupted)") {
+		t.Errorf("summary is not marked as partial:\n%s", out.String())
+	}
+}
+
+func TestPlainHTTPHasNoTLS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{
+		Request: req,
+		N:       10,
+		C:       2,
+		Writer:  io.Discard,
+	}
+	if err := w.Run(); err != nil {
+		t.Fatal(err)
+	}
+	rep := w.report.snapshot()
+	if rep.AvgTLS != 0 || rep.TlsMax != 0 || rep.TlsMin != 0 {
+		t.Errorf("AvgTLS, TlsMax, TlsMin = %v, %v, %v; want 0 without TLS", rep.AvgTLS, rep.TlsMax, rep.TlsMin)
+	}
+}
+
+func TestSnapshotDuringRun(t *testing.T) {
+	var w *Work
+	var snapshots int64
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		w.Snapshot()
+		atomic.AddInt64(&snapshots, 1)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w = &Work{
+		Request: req,
+		N:       50,
+		C:       5,
+		Writer:  io.Discard,
+	}
+	if rep := w.Snapshot(); rep.NumRes != 0 {
+		t.Errorf("Snapshot before the run has %d requests; want 0", rep.NumRes)
+	}
+	if err := w.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots != 50 {
+		t.Errorf("took %d snapshots; want 50", snapshots)
+	}
+	if rep := w.Snapshot(); rep.NumRes != 50 {
+		t.Errorf("Snapshot after the run has %d requests; want 50", rep.NumRes)
+	}
+}
+
+func TestErrorPhases(t *testing.T) {
+	// The response promises more bytes than it sends.
+	cut := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("short"))
+	}))
+	defer cut.Close()
+	// Nothing listens at the address of a closed server.
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	for url, want := range map[string]string{cut.URL: ErrPhaseRead, closed.URL: ErrPhaseConnect} {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := &Work{
+			Request: req,
+			N:       4,
+			C:       2,
+			Writer:  io.Discard,
+		}
+		if err := w.Run(); err != nil {
+			t.Fatal(err)
+		}
+		rep := w.report.snapshot()
+		if rep.NumErrs != 4 || rep.ErrorPhaseDist[want] != 4 {
+			t.Errorf("%s: %d errors by phase %v; want 4 in %q", url, rep.NumErrs, rep.ErrorPhaseDist, want)
+		}
+	}
+}
+
+func TestStartEndTime(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	out := &bytes.Buffer{}
+	before := time.Now()
+	w := &Work{Request: req, N: 20, C: 2, Output: "json", Writer: out}
+	w.Run()
+	var rep Report
+	if err := json.Unmarshal(out.Bytes(), &rep); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	if rep.StartTime.Before(before) || rep.EndTime.After(time.Now()) {
+		t.Errorf("StartTime, EndTime = %v, %v; want within the run", rep.StartTime, rep.EndTime)
+	}
+	if d := rep.EndTime.Sub(rep.StartTime) - rep.Total; d < -10*time.Millisecond || d > 10*time.Millisecond {
+		t.Errorf("EndTime - StartTime = %v; want about Total, %v", rep.EndTime.Sub(rep.StartTime), rep.Total)
+	}
+
+	out.Reset()
+	w = &Work{Request: req, N: 2, C: 1, Writer: out}
+	w.Run()
+	if !strings.Contains(out.String(), "\n  Start:\t") || !strings.Contains(out.String(), "\n  End:\t") {
+		t.Errorf("summary has no start and end time:\n%s", out)
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var works []*Work
+	for i, url := range []string{ok.URL, failing.URL} {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := &Work{Request: req, N: 10 + 20*i, C: 2, Writer: io.Discard}
+		w.Run()
+		works = append(works, w)
+	}
+	a, b := works[0].Snapshot(), works[1].Snapshot()
+	rep := MergeReports(works...)
+	if rep.NumRes != 40 || len(rep.Lats) != 40 {
+		t.Errorf("got %d results, %d samples; want 40, 40", rep.NumRes, len(rep.Lats))
+	}
+	if want := map[int]int{200: 10, 500: 30}; !reflect.DeepEqual(rep.StatusCodeDist, want) {
+		t.Errorf("StatusCodeDist = %v; want %v", rep.StatusCodeDist, want)
+	}
+	if rep.Total != a.Total+b.Total {
+		t.Errorf("Total = %v; want %v", rep.Total, a.Total+b.Total)
+	}
+	// The average of the merged samples, not the average of the averages.
+	if want := (10*a.Average + 30*b.Average) / 40; math.Abs(rep.Average-want) > 1e-9 {
+		t.Errorf("Average = %v; want %v", rep.Average, want)
+	}
+	if rep.SizeTotal != 50 {
+		t.Errorf("SizeTotal = %d; want 50", rep.SizeTotal)
+	}
+	if rep := MergeReports(); rep.NumRes != 0 {
+		t.Errorf("merging no runs: NumRes = %d; want 0", rep.NumRes)
+	}
+}
+
+func TestDistinctConnectionsKeepAlive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	w := &Work{Request: req, N: 30, C: 3, Writer: io.Discard}
+	w.Run()
+	// The workers keep their connections alive.
+	if got := w.report.snapshot().DistinctConnections; got < 1 || got > 3 {
+		t.Errorf("DistinctConnections = %d; want 1 to 3", got)
+	}
+}
+
+// mockReporter records the results and the calls to Finalize.
+type mockReporter struct {
+	results   []Result
+	finalized []time.Duration
+	err       error
+}
+
+func (m *mockReporter) Record(res Result) { m.results = append(m.results, res) }
+
+func (m *mockReporter) Finalize(total time.Duration) error {
+	m.finalized = append(m.finalized, total)
+	return m.err
+}
+
+func TestCustomReporter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	out := &bytes.Buffer{}
+	mock := &mockReporter{err: errors.New("sink is down")}
+	var onResult int
+	w := &Work{Request: req, N: 25, C: 5, Writer: out, Reporter: mock, OnResult: func(Result) { onResult++ }}
+	if err := w.Run(); err != mock.err {
+		t.Errorf("Run() = %v; want the error of Finalize", err)
+	}
+	if len(mock.results) != 25 || onResult != 25 {
+		t.Errorf("got %d results, OnResult called %d times; want 25, 25", len(mock.results), onResult)
+	}
+	for _, res := range mock.results {
+		if res.StatusCode != http.StatusTeapot || res.Duration <= 0 {
+			t.Errorf("got result %+v; want a 418 response", res)
+			break
+		}
+	}
+	if len(mock.finalized) != 1 || mock.finalized[0] <= 0 {
+		t.Errorf("Finalize calls = %v; want one with the duration of the run", mock.finalized)
+	}
+	if out.Len() != 0 {
+		t.Errorf("output = %q; want none from the builtin reporter", out)
+	}
+}
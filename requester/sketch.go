@@ -0,0 +1,191 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+)
+
+// sketchAccuracy is the relative accuracy of the latency sketch: the
+// quantiles it returns are within 0.5% of the exact sample quantiles.
+const sketchAccuracy = 0.005
+
+// sketchVersion is the first byte of an encoded sketch.
+const sketchVersion = 1
+
+var errBadSketch = errors.New("invalid latency sketch")
+
+// ddSketch is a DDSketch, a quantile sketch with relative accuracy
+// guarantees. Unlike the raw samples, the sketches of several runs can be
+// merged without loss of accuracy. Values are non-negative latencies.
+type ddSketch struct {
+	gamma float64
+	bins  map[int]uint64
+	zeros uint64
+	count uint64
+}
+
+func newDDSketch(accuracy float64) *ddSketch {
+	return &ddSketch{
+		gamma: (1 + accuracy) / (1 - accuracy),
+		bins:  make(map[int]uint64),
+	}
+}
+
+func (s *ddSketch) add(v float64) {
+	s.count++
+	if v <= 0 {
+		s.zeros++
+		return
+	}
+	s.bins[int(math.Ceil(math.Log(v)/math.Log(s.gamma)))]++
+}
+
+// merge adds the values of o to s. Both must have the same accuracy.
+func (s *ddSketch) merge(o *ddSketch) error {
+	if s.gamma != o.gamma {
+		return errors.New("cannot merge latency sketches of different accuracy")
+	}
+	for i, n := range o.bins {
+		s.bins[i] += n
+	}
+	s.zeros += o.zeros
+	s.count += o.count
+	return nil
+}
+
+// quantile returns the p-th percentile of the values.
+func (s *ddSketch) quantile(p float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := uint64(p / 100 * float64(s.count-1))
+	if rank < s.zeros {
+		return 0
+	}
+	seen := s.zeros
+	for _, i := range s.indexes() {
+		seen += s.bins[i]
+		if seen > rank {
+			return 2 * math.Pow(s.gamma, float64(i)) / (s.gamma + 1)
+		}
+	}
+	// Not reached, the bins add up to count.
+	return 0
+}
+
+func (s *ddSketch) indexes() []int {
+	idx := make([]int, 0, len(s.bins))
+	for i := range s.bins {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+// encode returns the binary encoding of the sketch: the version, gamma,
+// the number of zeros and of bins, and the bins as pairs of the delta
+// of their index to the previous one and their count.
+func (s *ddSketch) encode() []byte {
+	buf := []byte{sketchVersion}
+	buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(s.gamma))
+	buf = binary.AppendUvarint(buf, s.zeros)
+	buf = binary.AppendUvarint(buf, uint64(len(s.bins)))
+	var prev int
+	for _, i := range s.indexes() {
+		buf = binary.AppendVarint(buf, int64(i-prev))
+		buf = binary.AppendUvarint(buf, s.bins[i])
+		prev = i
+	}
+	return buf
+}
+
+func decodeSketch(data []byte) (*ddSketch, error) {
+	if len(data) < 9 || data[0] != sketchVersion {
+		return nil, errBadSketch
+	}
+	gamma := math.Float64frombits(binary.BigEndian.Uint64(data[1:9]))
+	if !(gamma > 1) {
+		return nil, errBadSketch
+	}
+	s := &ddSketch{gamma: gamma, bins: make(map[int]uint64)}
+	data = data[9:]
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			data = nil
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	s.zeros = uvarint()
+	numBins := uvarint()
+	if data == nil {
+		return nil, errBadSketch
+	}
+	s.count = s.zeros
+	var i int
+	for ; numBins > 0; numBins-- {
+		delta, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, errBadSketch
+		}
+		data = data[n:]
+		count := uvarint()
+		if data == nil {
+			return nil, errBadSketch
+		}
+		i += int(delta)
+		s.bins[i] += count
+		s.count += count
+	}
+	if len(data) > 0 {
+		return nil, errBadSketch
+	}
+	return s, nil
+}
+
+// MergeSketches merges two encoded latency sketches, see
+// Report.LatencySketch, e.g. to compute the latency distribution of
+// runs on several machines.
+func MergeSketches(a, b []byte) ([]byte, error) {
+	sa, err := decodeSketch(a)
+	if err != nil {
+		return nil, err
+	}
+	sb, err := decodeSketch(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := sa.merge(sb); err != nil {
+		return nil, err
+	}
+	return sa.encode(), nil
+}
+
+// SketchPercentile returns the p-th percentile of the latencies of an
+// encoded latency sketch, in seconds. It is within 0.5% of the exact
+// percentile of the samples.
+func SketchPercentile(sketch []byte, p float64) (float64, error) {
+	s, err := decodeSketch(sketch)
+	if err != nil {
+		return 0, err
+	}
+	return s.quantile(p), nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMergeSketches(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var all []float64
+	var merged []byte
+	// 20 machines with a log-normal latency around 20ms, and a slower tail
+	// on some of them.
+	for m := 0; m < 20; m++ {
+		r := newTestReport(0)
+		for i := 0; i < 5000; i++ {
+			v := 0.020 * math.Exp(rng.NormFloat64()*0.5)
+			if m%4 == 0 && i%50 == 0 {
+				v *= 10
+			}
+			r.lats = append(r.lats, v)
+			all = append(all, v)
+		}
+		sketch := r.sketch().encode()
+		if merged == nil {
+			merged = sketch
+			continue
+		}
+		var err error
+		if merged, err = MergeSketches(merged, sketch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Float64s(all)
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		want := percentile(all, p, NearestRank)
+		got, err := SketchPercentile(merged, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(got-want)/want > 0.01 {
+			t.Errorf("p%v = %v; want %v within 1%%", p, got, want)
+		}
+	}
+}
+
+func TestLatencySketchOutput(t *testing.T) {
+	results := []*result{
+		{statusCode: 200, duration: ms(10)},
+		{statusCode: 200, duration: ms(20)},
+	}
+	// The sketch is only built if it is asked for.
+	r := newTestReport(2)
+	feed(r, results...)
+	if s := r.snapshot(); s.LatencySketch != nil {
+		t.Errorf("LatencySketch = %v; want none", s.LatencySketch)
+	}
+	r = newTestReport(2)
+	r.latencySketch = true
+	feed(r, results...)
+	if got, err := SketchPercentile(r.snapshot().LatencySketch, 100); err != nil || math.Abs(got-0.020)/0.020 > sketchAccuracy {
+		t.Errorf("p100 of the sketch = %v, %v; want 0.020", got, err)
+	}
+
+	r = newTestReport(2)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "sketch"
+	feed(r, results...)
+	r.finalize(time.Second)
+	if !bytes.Equal(buf.Bytes(), r.sketch().encode()) {
+		t.Errorf("sketch output = %x; want %x", buf.Bytes(), r.sketch().encode())
+	}
+}
+
+func TestSketchEncoding(t *testing.T) {
+	s := newDDSketch(sketchAccuracy)
+	for _, v := range []float64{0, 0.001, 0.5, 0.5, 12} {
+		s.add(v)
+	}
+	data := s.encode()
+	d, err := decodeSketch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.count != 5 || d.zeros != 1 {
+		t.Errorf("count, zeros = %d, %d; want 5, 1", d.count, d.zeros)
+	}
+	if !bytes.Equal(d.encode(), data) {
+		t.Errorf("re-encoded sketch differs from the original")
+	}
+	if got, err := SketchPercentile(data, 0); err != nil || got != 0 {
+		t.Errorf("p0 = %v, %v; want 0", got, err)
+	}
+	if got, _ := SketchPercentile(data, 100); math.Abs(got-12)/12 > sketchAccuracy {
+		t.Errorf("p100 = %v; want 12", got)
+	}
+
+	if _, err := decodeSketch(data[:len(data)-1]); err == nil {
+		t.Errorf("decoding a truncated sketch succeeded")
+	}
+	if _, err := decodeSketch(append(data, 0)); err == nil {
+		t.Errorf("decoding a sketch with trailing data succeeded")
+	}
+	other := newDDSketch(0.01)
+	other.add(1)
+	if _, err := MergeSketches(data, other.encode()); err == nil {
+		t.Errorf("merging sketches of different accuracy succeeded")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SLORule is a service level objective on a metric of the report,
+// e.g. p99 < 0.2.
+type SLORule struct {
+	// Metric is a latency percentile, e.g. "p99" or "p99.9", or one of
+	// "average", "fastest", "slowest", "rps" and "errorRate". Latencies
+	// are in seconds and the error rate is a fraction.
+	Metric string
+	// Op is one of "<", "<=", ">" and ">=".
+	Op        string
+	Threshold float64
+}
+
+func (r SLORule) String() string {
+	return fmt.Sprintf("%s %s %v", r.Metric, r.Op, r.Threshold)
+}
+
+// SLOResult is the outcome of an SLO rule.
+type SLOResult struct {
+	Rule   SLORule
+	Actual float64
+	Passed bool
+	// NoLatencies is set if the rule is on the latencies but there are
+	// none, e.g. all the requests failed, in which case it fails.
+	NoLatencies bool
+}
+
+var sloOps = []string{"<=", ">=", "<", ">"}
+
+// ParseSLO parses a comma-separated list of SLO rules, such as
+// "p99<200ms,errorRate<0.01". Thresholds of latencies can be given as
+// durations.
+func ParseSLO(s string) ([]SLORule, error) {
+	var rules []SLORule
+	for _, expr := range strings.Split(s, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		var rule SLORule
+		for _, op := range sloOps {
+			if i := strings.Index(expr, op); i > 0 {
+				rule = SLORule{
+					Metric: strings.TrimSpace(expr[:i]),
+					Op:     op,
+				}
+				threshold := strings.TrimSpace(expr[i+len(op):])
+				if d, err := time.ParseDuration(threshold); err == nil {
+					rule.Threshold = d.Seconds()
+				} else if rule.Threshold, err = strconv.ParseFloat(threshold, 64); err != nil {
+					return nil, fmt.Errorf("invalid threshold in SLO %q", expr)
+				}
+				break
+			}
+		}
+		if rule.Op == "" {
+			return nil, fmt.Errorf("invalid SLO %q, want e.g. p99<200ms", expr)
+		}
+		if _, ok := sloPercentile(rule.Metric); !ok && !sloMetrics[rule.Metric] {
+			return nil, fmt.Errorf("unknown metric %q in SLO %q", rule.Metric, expr)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+var sloMetrics = map[string]bool{
+	"average":   true,
+	"fastest":   true,
+	"slowest":   true,
+	"rps":       true,
+	"errorRate": true,
+}
+
+// sloLatencyMetrics are the metrics, besides the percentiles, that
+// cannot be evaluated without latencies.
+var sloLatencyMetrics = map[string]bool{
+	"average": true,
+	"fastest": true,
+	"slowest": true,
+}
+
+// sloPercentile returns the percentile of a metric such as "p99".
+func sloPercentile(metric string) (float64, bool) {
+	if !strings.HasPrefix(metric, "p") {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(metric[1:], 64)
+	return p, err == nil && p >= 0 && p <= 100
+}
+
+// evaluateSLO evaluates the SLO rules of the report. It must be called
+// once the rates are computed.
+func (r *report) evaluateSLO() []SLOResult {
+	if len(r.slo) == 0 {
+		return nil
+	}
+	sorted := sortedCopy(r.lats)
+	pctl := func(p float64, method PercentileMethod) float64 {
+		if r.streamed != nil {
+			switch p {
+			case 0:
+				return r.streamed[50].min()
+			case 100:
+				return r.streamed[50].max()
+			}
+			return r.streamed[p].value()
+		}
+		return percentile(sorted, p, method)
+	}
+	res := make([]SLOResult, len(r.slo))
+	for i, rule := range r.slo {
+		var actual float64
+		p, isPercentile := sloPercentile(rule.Metric)
+		if (isPercentile || sloLatencyMetrics[rule.Metric]) && r.numLatencies() == 0 {
+			res[i] = SLOResult{Rule: rule, NoLatencies: true}
+			continue
+		}
+		if isPercentile {
+			actual = pctl(p, r.pctlMethod)
+		} else {
+			switch rule.Metric {
+			case "average":
+				actual = r.average()
+			case "fastest":
+				actual = pctl(0, NearestRank)
+			case "slowest":
+				actual = pctl(100, NearestRank)
+			case "rps":
+				actual = r.rps
+			case "errorRate":
+				actual = r.errorRate
+			}
+		}
+		var passed bool
+		switch rule.Op {
+		case "<":
+			passed = actual < rule.Threshold
+		case "<=":
+			passed = actual <= rule.Threshold
+		case ">":
+			passed = actual > rule.Threshold
+		case ">=":
+			passed = actual >= rule.Threshold
+		}
+		res[i] = SLOResult{Rule: rule, Actual: actual, Passed: passed}
+	}
+	return res
+}
@@ -0,0 +1,104 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSLO(t *testing.T) {
+	got, err := ParseSLO("p99<200ms, errorRate <= 0.01,rps>=100,p99.9>0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SLORule{
+		{Metric: "p99", Op: "<", Threshold: 0.2},
+		{Metric: "errorRate", Op: "<=", Threshold: 0.01},
+		{Metric: "rps", Op: ">=", Threshold: 100},
+		{Metric: "p99.9", Op: ">", Threshold: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got, err := ParseSLO(""); err != nil || got != nil {
+		t.Errorf("ParseSLO(\"\") = %v, %v; want no rules", got, err)
+	}
+	for _, s := range []string{"p99", "p99<fast", "p101<1", "latency<1", "<1"} {
+		if _, err := ParseSLO(s); err == nil {
+			t.Errorf("ParseSLO(%q) succeeded; want an error", s)
+		}
+	}
+}
+
+func TestSLO(t *testing.T) {
+	run := func(slo string) (*report, string, error) {
+		var results []*result
+		for i := 1; i <= 99; i++ {
+			results = append(results, &result{statusCode: 200, duration: ms(float64(i))})
+		}
+		results = append(results, &result{err: errors.New("timeout")})
+		r := newTestReport(len(results))
+		buf := &bytes.Buffer{}
+		r.w = buf
+		r.slo, _ = ParseSLO(slo)
+		feed(r, results...)
+		err := r.finalize(time.Second)
+		return r, buf.String(), err
+	}
+
+	r, out, err := run("p99<100ms,errorRate<0.02,rps>=100")
+	if err != nil {
+		t.Errorf("passing SLO returned error %v", err)
+	}
+	for i, res := range r.snapshot().SLOResults {
+		if !res.Passed {
+			t.Errorf("rule %d %v failed with %v; want passed", i, res.Rule, res.Actual)
+		}
+	}
+	if !strings.Contains(out, "[pass]\tp99 < 0.1 (actual 0.0990)") {
+		t.Errorf("summary does not list the passed rule:\n%s", out)
+	}
+
+	r, out, err = run("p50<=50ms,average<40ms,errorRate<0.01")
+	if err == nil || !strings.Contains(err.Error(), "average < 0.04 (actual 0.0500)") || !strings.Contains(err.Error(), "errorRate < 0.01 (actual 0.0100)") {
+		t.Errorf("failing SLO returned error %v; want the failed rules", err)
+	}
+	got := r.snapshot().SLOResults
+	if len(got) != 3 || !got[0].Passed || got[1].Passed || got[2].Passed {
+		t.Errorf("SLOResults = %+v; want the first rule passed only", got)
+	}
+	if !strings.Contains(out, "[FAIL]\taverage < 0.04 (actual 0.0500)") {
+		t.Errorf("summary does not list the failed rule:\n%s", out)
+	}
+}
+
+func TestSLONoLatencies(t *testing.T) {
+	r := newTestReport(2)
+	r.slo, _ = ParseSLO("p99<200ms,average<1s,errorRate<=1")
+	feed(r, &result{err: errors.New("timeout")}, &result{err: errors.New("timeout")})
+	err := r.finalize(time.Second)
+	if err == nil || !strings.Contains(err.Error(), "p99 < 0.2 (no latencies)") || !strings.Contains(err.Error(), "average < 1 (no latencies)") {
+		t.Errorf("finalize = %v; want the latency rules failed for lack of latencies", err)
+	}
+	got := r.snapshot().SLOResults
+	if len(got) != 3 || got[0].Passed || !got[0].NoLatencies || got[1].Passed || !got[2].Passed || got[2].NoLatencies {
+		t.Errorf("SLOResults = %+v; want the latency rules failed, the error rate one passed", got)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+
+package requester
+
+import (
+	"fmt"
+	"log/syslog"
+	"sort"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// writeSyslog writes the summary line of rep to syslog at the INFO
+// level, and a line per error message at the WARNING level. The messages
+// go to the syslog server at the UDP address addr, or to the local one
+// if addr is empty. The facility defaults to "user".
+func writeSyslog(addr, facility, tag string, rep Report) error {
+	if facility == "" {
+		facility = "user"
+	}
+	priority, ok := syslogFacilities[facility]
+	if !ok {
+		return fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	var w *syslog.Writer
+	var err error
+	if addr == "" {
+		w, err = syslog.New(priority|syslog.LOG_INFO, tag)
+	} else {
+		w, err = syslog.Dial("udp", addr, priority|syslog.LOG_INFO, tag)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting to syslog: %v", err)
+	}
+	defer w.Close()
+
+	if err := w.Info(summaryLine(rep)); err != nil {
+		return err
+	}
+	msgs := make([]string, 0, len(rep.ErrorDist))
+	for msg := range rep.ErrorDist {
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+	for _, msg := range msgs {
+		if err := w.Warning(fmt.Sprintf("ERRORS count=%d error=%q", rep.ErrorDist[msg], msg)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
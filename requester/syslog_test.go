@@ -0,0 +1,72 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+
+package requester
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := newTestReport(4)
+	r.syslogTag = "hey-test"
+	r.syslogFacility = "daemon"
+	r.syslogAddr = conn.LocalAddr().String()
+	feed(r,
+		&result{statusCode: 200, duration: ms(10)},
+		&result{statusCode: 200, duration: ms(20)},
+		&result{err: errors.New("connection refused")},
+		&result{err: errors.New("connection refused")},
+	)
+	if err := r.finalize(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The priority is the facility, daemon (3), times 8 plus the
+	// severity, INFO (6) or WARNING (4).
+	want := []struct{ priority, tag, msg string }{
+		{"<30>", "hey-test[", "SUMMARY requests=4 errors=2 rps=4.0 p50=0.0100 p99=0.0200"},
+		{"<28>", "hey-test[", `ERRORS count=2 error="connection refused"`},
+	}
+	buf := make([]byte, 1024)
+	for _, w := range want {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, w.priority) || !strings.Contains(msg, w.tag) || !strings.HasSuffix(strings.TrimSpace(msg), w.msg) {
+			t.Errorf("got message %q; want %s...%s...%s", msg, w.priority, w.tag, w.msg)
+		}
+	}
+}
+
+func TestWriteSyslogUnknownFacility(t *testing.T) {
+	if err := writeSyslog("127.0.0.1:1", "nope", "hey", Report{}); err == nil {
+		t.Error("writeSyslog with an unknown facility succeeded; want an error")
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// writeYAML writes v to w as YAML. Struct fields are named and omitted
+// as by encoding/json, durations are written as strings such as 1m30s
+// and times as RFC 3339 timestamps.
+func writeYAML(w io.Writer, v interface{}) error {
+	e := &yamlEncoder{}
+	e.node(reflect.ValueOf(v), 0)
+	_, err := w.Write(e.buf.Bytes())
+	return err
+}
+
+type yamlEncoder struct {
+	buf bytes.Buffer
+}
+
+// yamlField is a field of a mapping.
+type yamlField struct {
+	key   string
+	value reflect.Value
+}
+
+// node writes v, which starts on a new line, at the given indentation.
+func (e *yamlEncoder) node(v reflect.Value, indent int) {
+	v = indirect(v)
+	if s, ok := yamlScalar(v); ok {
+		e.pad(indent)
+		e.buf.WriteString(s)
+		e.buf.WriteByte('\n')
+		return
+	}
+	switch v.Kind() {
+	case reflect.Struct, reflect.Map:
+		e.mapping(yamlFields(v), indent, false)
+	default:
+		e.sequence(v, indent)
+	}
+}
+
+// mapping writes the fields. If inline, the first field continues the
+// current line, e.g. after the dash of a sequence item.
+func (e *yamlEncoder) mapping(fields []yamlField, indent int, inline bool) {
+	for i, f := range fields {
+		if i > 0 || !inline {
+			e.pad(indent)
+		}
+		e.buf.WriteString(f.key)
+		e.buf.WriteByte(':')
+		e.value(f.value, indent)
+	}
+}
+
+// sequence writes the elements of a non-empty slice or array of
+// non-scalars, one per item.
+func (e *yamlEncoder) sequence(v reflect.Value, indent int) {
+	for i := 0; i < v.Len(); i++ {
+		e.pad(indent)
+		item := indirect(v.Index(i))
+		if _, ok := yamlScalar(item); !ok && (item.Kind() == reflect.Struct || item.Kind() == reflect.Map) {
+			e.buf.WriteString("- ")
+			e.mapping(yamlFields(item), indent+2, true)
+			continue
+		}
+		e.buf.WriteByte('-')
+		e.value(item, indent)
+	}
+}
+
+// value writes v after a key or a dash: scalars on the same line,
+// mappings and sequences indented on the following lines.
+func (e *yamlEncoder) value(v reflect.Value, indent int) {
+	v = indirect(v)
+	if s, ok := yamlScalar(v); ok {
+		e.buf.WriteByte(' ')
+		e.buf.WriteString(s)
+		e.buf.WriteByte('\n')
+		return
+	}
+	e.buf.WriteByte('\n')
+	e.node(v, indent+2)
+}
+
+func (e *yamlEncoder) pad(indent int) {
+	e.buf.WriteString(strings.Repeat(" ", indent))
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// yamlScalar returns the YAML of v if it fits on one line: scalars,
+// empty and nil collections, and sequences of scalars in flow style.
+func yamlScalar(v reflect.Value) (string, bool) {
+	if !v.IsValid() {
+		return "null", true
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String(), true
+	}
+	if v.Type() == timeType {
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), true
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return yamlFloat(v.Float()), true
+	case reflect.String:
+		return strconv.Quote(v.String()), true
+	case reflect.Pointer, reflect.Interface:
+		return "null", true
+	case reflect.Map:
+		if v.IsNil() {
+			return "null", true
+		}
+		if v.Len() == 0 {
+			return "{}", true
+		}
+	case reflect.Struct:
+		if len(yamlFields(v)) == 0 {
+			return "{}", true
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return "null", true
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && v.Kind() == reflect.Slice {
+			// As encoding/json, bytes are base64 encoded.
+			return "!!binary " + base64.StdEncoding.EncodeToString(v.Bytes()), true
+		}
+		items := make([]string, v.Len())
+		for i := range items {
+			s, ok := yamlScalar(indirect(v.Index(i)))
+			if !ok {
+				return "", false
+			}
+			items[i] = s
+		}
+		return "[" + strings.Join(items, ", ") + "]", true
+	}
+	return "", false
+}
+
+func yamlFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return ".nan"
+	case math.IsInf(f, 1):
+		return ".inf"
+	case math.IsInf(f, -1):
+		return "-.inf"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eEn") {
+		// Keep floats apart from integers.
+		s += ".0"
+	}
+	return s
+}
+
+// yamlFields returns the fields of a struct, named as by encoding/json,
+// or the entries of a map, sorted by key.
+func yamlFields(v reflect.Value) []yamlField {
+	var fields []yamlField
+	if v.Kind() == reflect.Map {
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			switch keys[i].Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return keys[i].Int() < keys[j].Int()
+			}
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, k := range keys {
+			key := fmt.Sprint(k.Interface())
+			if k.Kind() == reflect.String {
+				key = strconv.Quote(key)
+			}
+			fields = append(fields, yamlField{key, v.MapIndex(k)})
+		}
+		return fields
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && isEmptyValue(v.Field(i)) {
+			continue
+		}
+		fields = append(fields, yamlField{name, v.Field(i)})
+	}
+	return fields
+}
+
+// isEmptyValue reports whether v is omitted by omitempty, as in
+// encoding/json.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Struct:
+		return false
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,203 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package requester
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type yamlLine struct {
+	indent int
+	text   string
+}
+
+// parseYAML parses the subset of YAML written by writeYAML.
+func parseYAML(t *testing.T, data string) interface{} {
+	t.Helper()
+	var lines []yamlLine
+	for _, l := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		text := strings.TrimLeft(l, " ")
+		lines = append(lines, yamlLine{len(l) - len(text), text})
+	}
+	v, n := parseYAMLBlock(t, lines, 0)
+	if n != len(lines) {
+		t.Fatalf("unparsed YAML from line %d: %q", n+1, lines[n].text)
+	}
+	return v
+}
+
+func parseYAMLBlock(t *testing.T, lines []yamlLine, i int) (interface{}, int) {
+	t.Helper()
+	indent := lines[i].indent
+	if strings.HasPrefix(lines[i].text, "-") {
+		var seq []interface{}
+		for i < len(lines) && lines[i].indent == indent && strings.HasPrefix(lines[i].text, "-") {
+			rest := strings.TrimPrefix(lines[i].text[1:], " ")
+			var v interface{}
+			switch {
+			case rest == "":
+				v, i = parseYAMLBlock(t, lines, i+1)
+			case strings.Contains(rest, ":"):
+				// A mapping starting on the line of the dash.
+				lines[i] = yamlLine{indent + 2, rest}
+				v, i = parseYAMLBlock(t, lines, i)
+			default:
+				v, i = parseYAMLScalar(t, rest), i+1
+			}
+			seq = append(seq, v)
+		}
+		return seq, i
+	}
+	m := map[string]interface{}{}
+	for i < len(lines) && lines[i].indent == indent {
+		text := lines[i].text
+		key, rest, ok := strings.Cut(text, ":")
+		if strings.HasPrefix(text, `"`) {
+			q, err := strconv.QuotedPrefix(text)
+			if err != nil {
+				t.Fatalf("invalid key in %q: %v", text, err)
+			}
+			key, _ = strconv.Unquote(q)
+			rest, ok = strings.CutPrefix(text[len(q):], ":")
+		}
+		if !ok {
+			t.Fatalf("line %d is not a key: %q", i+1, text)
+		}
+		rest = strings.TrimPrefix(rest, " ")
+		if rest == "" {
+			m[key], i = parseYAMLBlock(t, lines, i+1)
+		} else {
+			m[key], i = parseYAMLScalar(t, rest), i+1
+		}
+	}
+	return m, i
+}
+
+func parseYAMLScalar(t *testing.T, s string) interface{} {
+	t.Helper()
+	switch {
+	case s == "null":
+		return nil
+	case s == "true" || s == "false":
+		return s == "true"
+	case s == "{}":
+		return map[string]interface{}{}
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			t.Fatalf("invalid string %s: %v", s, err)
+		}
+		return v
+	case strings.HasPrefix(s, "["):
+		seq := []interface{}{}
+		if s = strings.Trim(s, "[]"); s != "" {
+			for _, item := range strings.Split(s, ", ") {
+				seq = append(seq, parseYAMLScalar(t, item))
+			}
+		}
+		return seq
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	// Plain strings, e.g. durations.
+	return s
+}
+
+func TestPrintYAML(t *testing.T) {
+	r := newTestReport(4)
+	buf := &bytes.Buffer{}
+	r.w = buf
+	r.output = "yaml"
+	feed(r,
+		&result{statusCode: 200, duration: ms(10), contentLength: 100},
+		&result{statusCode: 404, duration: ms(20), contentLength: 50},
+		&result{statusCode: 200, duration: ms(30), contentLength: 150},
+		&result{err: errors.New(`dial tcp: "refused"`)},
+	)
+	r.finalize(90 * time.Second)
+
+	got, ok := parseYAML(t, buf.String()).(map[string]interface{})
+	if !ok {
+		t.Fatalf("output is not a mapping:\n%s", buf)
+	}
+	for key, want := range map[string]interface{}{
+		"Total":     "1m30s",
+		"NumRes":    int64(4),
+		"NumErrs":   int64(1),
+		"Average":   0.02,
+		"SizeTotal": int64(300),
+		"Partial":   false,
+	} {
+		if got[key] != want {
+			t.Errorf("%s = %#v; want %#v", key, got[key], want)
+		}
+	}
+	if _, ok := got["SortedLats"]; ok {
+		t.Error("SortedLats is in the output; want it omitted as in JSON")
+	}
+	codes, _ := got["StatusCodeDist"].(map[string]interface{})
+	if codes["200"] != int64(2) || codes["404"] != int64(1) {
+		t.Errorf("StatusCodeDist = %v; want 2x200, 1x404", got["StatusCodeDist"])
+	}
+	errs, _ := got["ErrorDist"].(map[string]interface{})
+	if errs[`dial tcp: "refused"`] != int64(1) {
+		t.Errorf("ErrorDist = %v; want the error once", got["ErrorDist"])
+	}
+	if lats, _ := got["Lats"].([]interface{}); len(lats) != 3 {
+		t.Errorf("Lats = %v; want 3 latencies", got["Lats"])
+	}
+	hist, _ := got["Histogram"].([]interface{})
+	if len(hist) == 0 {
+		t.Fatalf("Histogram = %v; want buckets", got["Histogram"])
+	}
+	if b, _ := hist[0].(map[string]interface{}); b["Mark"] != 0.01 {
+		t.Errorf("first bucket = %v; want a mark of 0.01", hist[0])
+	}
+}
+
+func TestYAMLScalars(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{90 * time.Second, "1m30s"},
+		{1.0, "1.0"},
+		{0.25, "0.25"},
+		{int64(3), "3"},
+		{"a: b", `"a: b"`},
+		{[]float64{1, 0.5}, "[1.0, 0.5]"},
+		{[]int(nil), "null"},
+		{map[string]int{}, "{}"},
+		{[]byte("hi"), "!!binary aGk="},
+	}
+	for _, tt := range tests {
+		buf := &bytes.Buffer{}
+		if err := writeYAML(buf, tt.v); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(buf.String()); got != tt.want {
+			t.Errorf("writeYAML(%#v) = %s; want %s", tt.v, got, tt.want)
+		}
+	}
+}